@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// context keys of the authenticated user and of the token they came with
+const (
+	callerKey = "caller"
+	tokenKey  = "token"
+)
+
+// defaultTokenTTL is the lifetime of API tokens when the config sets none
+const defaultTokenTTL = 24 * time.Hour
+
+// authenticate resolves the caller from an "Authorization: Bearer <token>"
+// header, requests without the header go on anonymously while other schemes
+// and unknown or expired tokens are refused. Requests with the header are rate
+// limited here, see rateLimit.
+func (db *DB) authenticate(c *gin.Context) {
+	header := c.Request.Header.Get("Authorization")
+	if header == "" {
+		return
+	}
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || strings.TrimSpace(parts[1]) == "" {
+		db.refuse(c, ErrUnauthorized)
+		return
+	}
+	// an address out of tokens for failed attempts gets no more lookups
+	if wait := db.limits(c).Wait("auth:" + remoteIP(c)); wait > 0 {
+		tooMany(c, wait)
+		return
+	}
+	token := strings.TrimSpace(parts[1])
+	email, err := db.Store.TokenUser(tokenHash(token), time.Now().Format(dateFormat))
+	if err == ErrNotFound {
+		err = ErrUnauthorized
+	}
+	if err != nil {
+		db.refuse(c, err)
+		return
+	}
+	if db.limit(c, "user:"+email) {
+		c.Set(callerKey, email)
+		c.Set(tokenKey, token)
+	}
+}
+
+// refuse aborts a request that failed authentication, the failure counts
+// against the failed attempts of its IP address
+func (db *DB) refuse(c *gin.Context, err error) {
+	if db.limit(c, "auth:"+remoteIP(c)) {
+		fail(c, err)
+		c.Abort()
+	}
+}
+
+// identify replaces user taken from a request body with the authenticated
+// caller. In open mode the body is trusted and user stays as it is.
+func (db *DB) identify(c *gin.Context, user *string) error {
+	if !db.Auth {
+		return nil
+	}
+	email, ok := c.Get(callerKey)
+	if !ok {
+		return ErrUnauthorized
+	}
+	*user = email.(string)
+	return nil
+}
+
+// checkOwner lets only the owner manage a forum, everybody may in open mode
+func (db *DB) checkOwner(c *gin.Context, shortName string) error {
+	var user string
+	if err := db.identify(c, &user); err != nil || !db.Auth {
+		return err
+	}
+	forum, err := db.Store.Forum(shortName)
+	if err != nil {
+		return err
+	}
+	if forum.User != user {
+		return ErrNotOwner
+	}
+	return nil
+}
+
+// newToken returns a random API token
+func newToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// tokenHash is what is stored of a token, tokens are random enough for a
+// single SHA-256 to be safe and a leaked table gives nobody a valid token
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashPassword returns a bcrypt hash of password
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// checkPassword tells whether password matches a hash made by hashPassword
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// login returns a token of user, it fails the test when there is none
+func login(t *testing.T, c client, user, password string) string {
+	t.Helper()
+	r := c.post("/db/api/user/login/", map[string]interface{}{"user": user, "password": password})
+	response, ok := r["response"].(map[string]interface{})
+	if !ok || response["token"] == "" || response["expires"] == "" {
+		t.Fatal("login", r)
+	}
+	return response["token"].(string)
+}
+
+// withAuth serves the API of c in auth mode
+func (c client) withAuth() client {
+	c.db.Auth = true
+	c.r = newRouter(c.db)
+	return c
+}
+
+// status requests the status with header as Authorization and returns the body
+func status(c client, header string) string {
+	req := httptest.NewRequest("GET", "/db/api/status/", nil)
+	req.Header.Set("Authorization", header)
+	w := httptest.NewRecorder()
+	c.r.ServeHTTP(w, req)
+	return w.Body.String()
+}
+
+func TestAuth(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c client) {
+		c = c.withAuth()
+		c.post("/db/api/user/create/", map[string]interface{}{"email": "a@a", "password": "pw"})
+		c.post("/db/api/user/create/", map[string]interface{}{"email": "b@b", "password": "pw2"})
+		expectCode(t, "bad password", c.post("/db/api/user/login/", map[string]interface{}{"user": "a@a", "password": "x"}), 6)
+		owner, other := login(t, c, "a@a", "pw"), login(t, c, "b@b", "pw2")
+
+		forum := map[string]interface{}{"name": "F", "short_name": "f", "user": "b@b"}
+		expectCode(t, "anonymous", c.post("/db/api/forum/create/", forum), 6)
+		expectCode(t, "unknown token", c.do("POST", "/db/api/forum/create/", "nope", forum), 6)
+		r := c.do("POST", "/db/api/forum/create/", owner, forum)
+		if r["response"].(map[string]interface{})["user"] != "a@a" {
+			t.Error("the caller owns the forum", r)
+		}
+		expectCode(t, "not owner", c.do("POST", "/db/api/forum/remove/", other, map[string]interface{}{"forum": "f"}), 3)
+		expectCode(t, "owner", c.do("POST", "/db/api/forum/updatePolicy/", owner, map[string]interface{}{"forum": "f", "moderatorsPostClosed": true}), 0)
+		expectCode(t, "clear", c.post("/db/api/clear/", nil), 6)
+
+		for _, header := range []string{other, "Basic " + other, "Bearer", "Bearer "} {
+			if body := status(c, header); !strings.Contains(body, `"code":6`) {
+				t.Errorf("header %q: %s", header, body)
+			}
+		}
+
+		c.do("POST", "/db/api/thread/create/", owner, map[string]interface{}{"forum": "f", "title": "t", "isClosed": false,
+			"date": "2014-01-01 00:00:00", "message": "m", "slug": "t"})
+		c.do("POST", "/db/api/post/create/", owner, map[string]interface{}{"forum": "f", "thread": 1, "date": "2014-01-01 00:00:00", "message": "m"})
+		expectCode(t, "foreign post", c.do("POST", "/db/api/post/update/", other, map[string]interface{}{"post": 1, "message": "x"}), 3)
+		expectCode(t, "foreign thread", c.do("POST", "/db/api/thread/update/", other, map[string]interface{}{"thread": 1, "message": "x", "slug": "x"}), 3)
+		expectCode(t, "own post", c.do("POST", "/db/api/post/update/", owner, map[string]interface{}{"post": 1, "message": "x"}), 0)
+		expectCode(t, "foreign revert", c.do("POST", "/db/api/post/history/", other, map[string]interface{}{"post": 1, "revision": 1}), 3)
+		expectCode(t, "foreign remove", c.do("POST", "/db/api/post/remove/", other, map[string]interface{}{"post": 1}), 3)
+		expectCode(t, "grant", c.do("POST", "/db/api/forum/grant/", owner, map[string]interface{}{"forum": "f", "target": "b@b", "role": "moderator"}), 0)
+		expectCode(t, "moderator", c.do("POST", "/db/api/post/update/", other, map[string]interface{}{"post": 1, "message": "y"}), 0)
+
+		r = c.do("POST", "/db/api/user/follow/", other, map[string]interface{}{"follower": "a@a", "followee": "a@a"})
+		if r["response"].(map[string]interface{})["email"] != "b@b" {
+			t.Error("the caller follows", r)
+		}
+		expectCode(t, "logout", c.do("POST", "/db/api/user/logout/", other, nil), 0)
+		expectCode(t, "after logout", c.do("POST", "/db/api/user/follow/", other, map[string]interface{}{"followee": "a@a"}), 6)
+
+		c.db.TokenTTL = -time.Second
+		expired := login(t, c, "b@b", "pw2")
+		expectCode(t, "expired", c.do("POST", "/db/api/user/follow/", expired, map[string]interface{}{"followee": "a@a"}), 6)
+	})
+}
+
+func TestOpenMode(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c client) {
+		seed(c)
+		// tokens are ignored, a stale one does not lock clients out
+		if body := status(c, "Bearer stale"); !strings.Contains(body, `"code":0`) {
+			t.Error("stale token", body)
+		}
+		createPost(c, "2014-01-02 00:00:00", nil)
+		expectCode(t, "remove without user", c.post("/db/api/post/remove/", map[string]interface{}{"post": 1}), 0)
+		expectCode(t, "restore by member", c.post("/db/api/post/restore/", map[string]interface{}{"post": 1, "user": "b@b"}), 3)
+		expectCode(t, "edit by member", c.post("/db/api/post/update/", map[string]interface{}{"post": 1, "message": "x", "user": "b@b"}), 3)
+		expectCode(t, "clear", c.post("/db/api/clear/", nil), 0)
+	})
+}
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := hashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkPassword(hash, "secret") || checkPassword(hash, "Secret") || checkPassword("", "") {
+		t.Error(hash)
+	}
+	if tokenHash("a") == tokenHash("b") || len(tokenHash("a")) != 64 {
+		t.Error(tokenHash("a"))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// cache keeps recently used responses in process memory, entries are evicted
+// when the cache is full or their ttl has passed
+type cache struct {
+	mu     sync.Mutex
+	size   int
+	ttl    time.Duration
+	items  map[string]*list.Element
+	order  *list.List
+	hits   int64
+	misses int64
+}
+
+type cacheEntry struct {
+	key     string
+	value   gin.H
+	expires time.Time
+}
+
+// newCache returns a cache of size entries, a zero size disables caching
+func newCache(size int, ttl time.Duration) *cache {
+	return &cache{size: size, ttl: ttl, items: map[string]*list.Element{}, order: list.New()}
+}
+
+// Get returns a copy of the cached value, callers are free to change it
+func (c *cache) Get(key string) (gin.H, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.items[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(element)
+			c.hits++
+			return copyH(entry.value), true
+		}
+		c.remove(element)
+	}
+	c.misses++
+	return nil, false
+}
+
+// Set stores a copy of value under key
+func (c *cache) Set(key string, value gin.H) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, value: copyH(value), expires: time.Now().Add(c.ttl)})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete invalidates the given keys
+func (c *cache) Delete(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if element, ok := c.items[key]; ok {
+			c.remove(element)
+		}
+	}
+}
+
+// Purge invalidates every entry
+func (c *cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = map[string]*list.Element{}
+	c.order.Init()
+}
+
+// Stats returns hit and miss counters
+func (c *cache) Stats() (int64, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+func (c *cache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*cacheEntry).key)
+}
+
+func copyH(value gin.H) gin.H {
+	result := make(gin.H, len(value))
+	for key, item := range value {
+		result[key] = item
+	}
+	return result
+}
+
+func forumKey(shortName string) string {
+	return "forum:" + shortName
+}
+
+func threadKey(id int) string {
+	return "thread:" + strconv.Itoa(id)
+}
+
+func userKey(email string) string {
+	return "user:" + email
+}
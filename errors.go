@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// APIError is an error reported to clients with one of the documented codes
+type APIError struct {
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// API errors
+var (
+	ErrNotFound  = &APIError{1, "Not found"}
+	ErrInvalid   = &APIError{2, "Invalid request"}
+	ErrIncorrect = &APIError{3, "Incorrect request"}
+	ErrUnknown   = &APIError{4, "Unknown error"}
+	ErrExists    = &APIError{5, "Already exists"}
+	// ErrUnauthorized is reported when authentication is on and the caller is unknown
+	ErrUnauthorized = &APIError{6, "Unauthorized"}
+	// ErrRateLimited is reported to clients over their request rate, see rateLimit
+	ErrRateLimited = &APIError{7, "Too many requests"}
+
+	ErrThreadClosed  = &APIError{3, "Thread is closed"}
+	ErrThreadDeleted = &APIError{3, "Thread is deleted"}
+	ErrNotModerator  = &APIError{3, "User is not a moderator of the forum"}
+	ErrNotOwner      = &APIError{3, "User is not the owner of the forum"}
+	ErrBanned        = &APIError{3, "User is banned in the forum"}
+	ErrOwnsForum     = &APIError{3, "User owns a forum"}
+	ErrNotAuthor     = &APIError{3, "User is not the author"}
+)
+
+// apiError returns err as an API error, errors without a code are unknown ones
+func apiError(err error) *APIError {
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		log.Error(err)
+		apiErr = ErrUnknown
+	}
+	return apiErr
+}
+
+// fail writes err as an API error
+func fail(c *gin.Context, err error) {
+	apiErr := apiError(err)
+	c.JSON(http.StatusOK, gin.H{"code": apiErr.Code, "response": apiErr.Message})
+}
+
+// parseBody decodes the JSON body of a request into obj
+func parseBody(c *gin.Context, obj interface{}) error {
+	if err := json.NewDecoder(c.Request.Body).Decode(obj); err != nil {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// boolQuery reads an optional true or false query param
+func boolQuery(c *gin.Context, name string) (bool, error) {
+	switch c.Query(name) {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	}
+	return false, ErrIncorrect
+}
+
+// intQuery parses a required integer query parameter
+func intQuery(c *gin.Context, name string) (int, error) {
+	value, err := strconv.Atoi(c.Query(name))
+	if err != nil {
+		return 0, ErrInvalid
+	}
+	return value, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// loader collects users and threads referenced by a page and fetches all of
+// them at once, so a page costs the same number of queries whatever its size
+type loader struct {
+	Store   Store
+	Cache   *cache
+	emails  []string
+	ids     []int
+	users   map[string]gin.H
+	threads map[int]gin.H
+}
+
+func newLoader(store Store, cache *cache) *loader {
+	return &loader{Store: store, Cache: cache, users: map[string]gin.H{}, threads: map[int]gin.H{}}
+}
+
+// AddUser schedules the user with email for the next Load unless it is cached
+func (l *loader) AddUser(email string) {
+	if _, ok := l.users[email]; !ok {
+		if l.users[email], ok = l.Cache.Get(userKey(email)); !ok {
+			l.emails = append(l.emails, email)
+		}
+	}
+}
+
+// AddThread schedules the thread with id for the next Load unless it is cached
+func (l *loader) AddThread(id int) {
+	if _, ok := l.threads[id]; !ok {
+		if l.threads[id], ok = l.Cache.Get(threadKey(id)); !ok {
+			l.ids = append(l.ids, id)
+		}
+	}
+}
+
+// Load fetches every scheduled user and thread
+func (l *loader) Load() error {
+	if err := l.loadUsers(); err != nil {
+		return err
+	}
+	return l.loadThreads()
+}
+
+func (l *loader) loadUsers() error {
+	if len(l.emails) == 0 {
+		return nil
+	}
+	users, err := l.Store.Users(l.emails)
+	if err != nil {
+		return err
+	}
+	follows, err := l.Store.Follows(l.emails)
+	if err != nil {
+		return err
+	}
+	subs, err := l.Store.Subscriptions(l.emails)
+	if err != nil {
+		return err
+	}
+
+	followers := map[string][]string{}
+	following := map[string][]string{}
+	for _, follow := range follows {
+		followers[follow.Following] = append(followers[follow.Following], follow.Follower)
+		following[follow.Follower] = append(following[follow.Follower], follow.Following)
+	}
+	threads := map[string][]int{}
+	for _, sub := range subs {
+		threads[sub.User] = append(threads[sub.User], sub.Thread)
+	}
+	for _, user := range users {
+		l.users[user.Email] = userResponse(user, followers[user.Email], following[user.Email], threads[user.Email])
+		l.Cache.Set(userKey(user.Email), l.users[user.Email])
+	}
+	l.emails = nil
+	return nil
+}
+
+func (l *loader) loadThreads() error {
+	if len(l.ids) == 0 {
+		return nil
+	}
+	threads, err := l.Store.Threads(l.ids)
+	if err != nil {
+		return err
+	}
+	for _, thread := range threads {
+		l.threads[thread.ID] = threadResponse(thread)
+		l.Cache.Set(threadKey(thread.ID), l.threads[thread.ID])
+	}
+	l.ids = nil
+	return nil
+}
+
+// User returns a loaded user, ErrNotFound if there is no such user
+func (l *loader) User(email string) (gin.H, error) {
+	if user := l.users[email]; user != nil {
+		return user, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Thread returns a loaded thread, ErrNotFound if there is no such thread
+func (l *loader) Thread(id int) (gin.H, error) {
+	if thread := l.threads[id]; thread != nil {
+		return thread, nil
+	}
+	return nil, ErrNotFound
+}
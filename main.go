@@ -3,12 +3,13 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/go-gorp/gorp"
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/op/go-logging"
 	"gopkg.in/gin-gonic/gin.v1"
 )
@@ -19,15 +20,39 @@ var format = logging.MustStringFormatter(`%{color} %{shortfunc} ▶ %{level:.5s}
 func main() {
 	logging.SetFormatter(format)
 	config := loadConfig()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		errCheck(runMigrate(&config, os.Args[2:]))
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		dbmap := initDB(&config)
+		errCheck(dbmap.Store.RebuildPaths())
+		errCheck(dbmap.Store.Close())
+		return
+	}
 	dbmap := initDB(&config)
-	defer dbmap.Map.Db.Close()
+	defer dbmap.Store.Close()
+	defer dbmap.Reads.Stop()
+	defer dbmap.Writes.Stop()
 	gin.SetMode(gin.ReleaseMode)
+	router := newRouter(dbmap)
+	err := router.Run(":" + config.PORT)
+	errCheck(err)
+}
+
+func newRouter(dbmap *DB) *gin.Engine {
 	router := gin.Default()
+	router.Use(dbmap.rateLimit)
+	if dbmap.Auth {
+		// open mode ignores tokens, stale Authorization headers included
+		router.Use(dbmap.authenticate)
+	}
 
 	common := router.Group("/db/api/")
 	{
 		common.POST("clear/", dbmap.commonClear)
 		common.GET("status/", dbmap.commonStatus)
+		common.GET("search/", dbmap.commonSearch)
 	}
 	forum := router.Group("/db/api/forum/")
 	{
@@ -36,14 +61,26 @@ func main() {
 		forum.GET("listPosts/", dbmap.forumListPosts)
 		forum.GET("listThreads/", dbmap.forumListThreads)
 		forum.GET("listUsers/", dbmap.forumListUsers)
+		forum.GET("moderationQueue/", dbmap.forumModerationQueue)
+		forum.POST("updatePolicy/", dbmap.forumUpdatePolicy)
+		forum.POST("update/", dbmap.forumUpdate)
+		forum.POST("rename/", dbmap.forumRename)
+		forum.POST("remove/", dbmap.forumRemove)
+		forum.POST("restore/", dbmap.forumRestore)
+		forum.POST("grant/", dbmap.forumGrant)
+		forum.POST("revoke/", dbmap.forumRevoke)
+		forum.GET("listRoles/", dbmap.forumListRoles)
 	}
 	thread := router.Group("/db/api/thread/")
 	{
 		thread.POST("create/", dbmap.threadCreate)
 		thread.GET("details/", dbmap.threadDetails)
+		thread.GET("history/", dbmap.threadHistory)
+		thread.POST("history/", dbmap.threadRevert)
 		thread.POST("close/", dbmap.threadClose)
 		thread.GET("list/", dbmap.threadList)
 		thread.GET("listPosts/", dbmap.threadListPosts)
+		thread.GET("listVoters/", dbmap.threadListVoters)
 		thread.POST("open/", dbmap.threadOpen)
 		thread.POST("remove/", dbmap.threadRemove)
 		thread.POST("restore/", dbmap.threadRestore)
@@ -56,15 +93,27 @@ func main() {
 	{
 		post.POST("create/", dbmap.postCreate)
 		post.GET("details/", dbmap.postDetails)
+		post.GET("history/", dbmap.postHistory)
+		post.POST("history/", dbmap.postRevert)
 		post.GET("list/", dbmap.postList)
+		post.GET("listVoters/", dbmap.postListVoters)
+		post.GET("replies/", dbmap.postReplies)
 		post.POST("remove/", dbmap.postRemove)
 		post.POST("restore/", dbmap.postRestore)
 		post.POST("update/", dbmap.postUpdate)
+		post.POST("approve/", dbmap.postApprove)
+		post.POST("unapprove/", dbmap.postUnapprove)
+		post.POST("markSpam/", dbmap.postMarkSpam)
+		post.POST("unmarkSpam/", dbmap.postUnmarkSpam)
+		post.POST("highlight/", dbmap.postHighlight)
+		post.POST("unhighlight/", dbmap.postUnhighlight)
 		post.POST("vote/", dbmap.postVote)
 	}
 	user := router.Group("/db/api/user/")
 	{
 		user.POST("create/", dbmap.userCreate)
+		user.POST("login/", dbmap.userLogin)
+		user.POST("logout/", dbmap.userLogout)
 		user.GET("details/", dbmap.userDetails)
 		user.POST("follow/", dbmap.userFollow)
 		user.GET("listFollowers/", dbmap.userFollowersList)
@@ -72,10 +121,10 @@ func main() {
 		user.GET("listPosts/", dbmap.userListPosts)
 		user.POST("unfollow/", dbmap.userUnfollow)
 		user.POST("updateProfile/", dbmap.userUpdate)
+		user.POST("remove/", dbmap.userRemove)
+		user.GET("export/", dbmap.userExport)
 	}
-
-	err := router.Run(":" + config.PORT)
-	errCheck(err)
+	return router
 }
 
 func errCheck(err error) {
@@ -95,13 +144,63 @@ func loadConfig() Config {
 	return conf
 }
 
-func initDB(config *Config) *DB {
-	connection := config.USER + ":" + config.PASS + "@/" + config.DB + "?charset=utf8"
+func openDB(config *Config) *gorp.DbMap {
+	switch config.DIAL {
+	case "sqlite3":
+		db, err := sql.Open("sqlite3", config.DB)
+		errCheck(err)
+		db.SetMaxOpenConns(1)
+		return &gorp.DbMap{Db: db, Dialect: gorp.SqliteDialect{}}
+	case "postgres":
+		connection := "host=" + config.HOST + " user=" + config.USER + " password=" + config.PASS + " dbname=" + config.DB + " sslmode=disable"
+		db, err := sql.Open("postgres", connection)
+		errCheck(err)
+		db.SetMaxIdleConns(100)
+		return &gorp.DbMap{Db: db, Dialect: gorp.PostgresDialect{}}
+	}
+	connection := config.USER + ":" + config.PASS + "@/" + config.DB + "?charset=utf8&max_sort_length=8388608"
 	db, err := sql.Open("mysql", connection)
 	errCheck(err)
 	db.SetMaxIdleConns(100)
-	dbmap := &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{Encoding: "utf8", Engine: "InnoDB"}}
-	return &DB{Map: dbmap}
+	return &gorp.DbMap{Db: db, Dialect: gorp.MySQLDialect{Encoding: "utf8", Engine: "InnoDB"}}
+}
+
+func initDB(config *Config) *DB {
+	tokenTTL := time.Duration(config.TOKEN_TTL) * time.Second
+	if tokenTTL <= 0 {
+		tokenTTL = defaultTokenTTL
+	}
+	return &DB{Store: openStore(config), Cache: newCache(config.CACHE, time.Duration(config.TTL)*time.Second), Auth: config.AUTH,
+		TokenTTL: tokenTTL, Reads: newLimiter(config.LIMITS.READ), Writes: newLimiter(config.LIMITS.WRITE)}
+}
+
+func openStore(config *Config) Store {
+	if config.DIAL == "memory" {
+		return newMemoryStore()
+	}
+	dbmap := openDB(config)
+	migrations, err := newMigrator(dbmap, config.DIAL)
+	errCheck(err)
+	errCheck(migrations.Up(0))
+	switch config.DIAL {
+	case "sqlite3":
+		return backfillPaths(dbmap, newSQLiteStore(dbmap))
+	case "postgres":
+		return newPostgresStore(dbmap)
+	}
+	return backfillPaths(dbmap, newMySQLStore(dbmap))
+}
+
+// backfillPaths rebuilds the post paths of rows written before migration
+// 0002, it marks them with a negative children counter
+func backfillPaths(dbmap *gorp.DbMap, store Store) Store {
+	stale, err := dbmap.SelectInt("select count(*) from post where children < 0")
+	errCheck(err)
+	if stale > 0 {
+		log.Infof("rebuilding paths of %d posts", stale)
+		errCheck(store.RebuildPaths())
+	}
+	return store
 }
 
 // Config struct
@@ -113,11 +212,38 @@ type Config struct {
 	PATH string
 	USER string
 	PASS string
+	// CACHE is the number of cached details responses, TTL their lifetime in seconds
+	CACHE int
+	TTL   int
+	// AUTH makes write endpoints act on behalf of the token owner instead of
+	// the user named in the body
+	AUTH bool
+	// TOKEN_TTL is the lifetime of API tokens in seconds, a day when unset
+	TOKEN_TTL int
+	// LIMITS are request rates per client for reads and writes
+	LIMITS struct {
+		READ  Limit
+		WRITE Limit
+	}
+}
+
+// Limit is a request rate in requests a second with bursts of up to BURST
+// requests, a zero RATE means no limit
+type Limit struct {
+	RATE  float64
+	BURST int
 }
 
 // DB wrapper
 type DB struct {
-	Map *gorp.DbMap
+	Store Store
+	Cache *cache
+	Auth  bool
+	// TokenTTL is how long an API token stays valid after login
+	TokenTTL time.Duration
+	// Reads and Writes limit GET and POST requests of every client
+	Reads  *limiter
+	Writes *limiter
 }
 
 // Related entities
@@ -133,6 +259,9 @@ type Forum struct {
 	Name      string `json:"name" db:"name"`
 	ShortName string `json:"short_name" db:"short_name"`
 	User      string `json:"user" db:"user"`
+	IsDeleted bool   `json:"isDeleted" db:"isDeleted"`
+	// ModeratorsPostClosed lets moderators keep posting in closed threads
+	ModeratorsPostClosed bool `json:"moderatorsPostClosed" db:"moderatorsPostClosed"`
 }
 
 // User entity
@@ -164,6 +293,9 @@ type Post struct {
 	User          string `json:"user" db:"user"`
 	FirstPath     int    `json:"first_path" db:"first_path"`
 	LastPath      string `json:"last_path" db:"last_path"`
+	Children      int    `json:"-" db:"children"`
+	// Depth is the tree level from the stored path, set by thread post lists
+	Depth int `json:"-" db:"-"`
 }
 
 // Thread entity
@@ -189,6 +321,65 @@ type Follow struct {
 	Following string `json:"followee" db:"following"`
 }
 
+// Vote entity, vote is 1 for a like and -1 for a dislike
+type Vote struct {
+	User   string `json:"user,omitempty" db:"user"`
+	Thread int    `json:"thread,omitempty" db:"thread"`
+	Post   int    `json:"post,omitempty" db:"post"`
+	Vote   int    `json:"vote" db:"vote"`
+}
+
+// Revision entity, the message of a post or a thread before an edit by user
+type Revision struct {
+	ID      int    `json:"id" db:"id"`
+	Thread  int    `json:"thread,omitempty" db:"thread"`
+	Post    int    `json:"post,omitempty" db:"post"`
+	User    string `json:"user" db:"user"`
+	Date    string `json:"date" db:"date"`
+	Message string `json:"message" db:"message"`
+	Slug    string `json:"slug,omitempty" db:"slug"`
+}
+
+// Role entity, the part a user plays in a forum
+type Role struct {
+	User string `json:"user" db:"user"`
+	Role string `json:"role" db:"role"`
+}
+
+// forum roles, the owner is the user of the forum and is never stored, users
+// without a stored role are members
+const (
+	roleOwner     = "owner"
+	roleModerator = "moderator"
+	roleMember    = "member"
+	roleBanned    = "banned"
+)
+
+// SearchResult entity, a post or a thread found by a search, thread is the
+// id of the thread itself for threads
+type SearchResult struct {
+	Type      string  `json:"type" db:"type"`
+	ID        int     `json:"id" db:"id"`
+	Score     float64 `json:"score" db:"score"`
+	Forum     string  `json:"forum" db:"forum"`
+	User      string  `json:"user" db:"user"`
+	Date      string  `json:"date" db:"date"`
+	Thread    int     `json:"thread" db:"thread"`
+	Title     string  `json:"title,omitempty" db:"title"`
+	Message   string  `json:"message" db:"message"`
+	IsDeleted bool    `json:"isDeleted" db:"isDeleted"`
+	IsSpam    bool    `json:"isSpam" db:"isSpam"`
+}
+
+// dateFormat is the layout of dates in requests and responses
+const dateFormat = "2006-01-02 15:04:05"
+
+// Subscription entity
+type Subscription struct {
+	User   string `json:"user" db:"user"`
+	Thread int    `json:"thread" db:"thread"`
+}
+
 // UpdateUser entity
 type UpdateUser struct {
 	About string `json:"about"`
@@ -197,7 +388,7 @@ type UpdateUser struct {
 }
 
 // COMMON METHODS
-func relate(entities []string) Related {
+func relate(entities []string) (Related, error) {
 	rel := Related{false, false, false}
 	for _, entity := range entities {
 		if entity == "user" {
@@ -206,663 +397,2385 @@ func relate(entities []string) Related {
 			rel.Forum = true
 		} else if entity == "thread" {
 			rel.Thread = true
+		} else {
+			return rel, ErrIncorrect
+		}
+	}
+	return rel, nil
+}
+
+// page reads since, order and limit params, order must be asc or desc and limit positive
+func page(c *gin.Context, since string) (Page, error) {
+	list := Page{Since: c.Query(since), Order: c.DefaultQuery("order", "desc")}
+	if _, err := direction(list.Order); err != nil {
+		return list, err
+	}
+	if limit := c.Query("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 {
+			return list, ErrIncorrect
+		}
+		list.Limit = value
+	}
+	return list, nil
+}
+
+// hide reads hide params of post lists, spam and unapproved are allowed
+func hide(c *gin.Context, list *Page) error {
+	for _, value := range c.Request.URL.Query()["hide"] {
+		switch value {
+		case "spam":
+			list.HideSpam = true
+		case "unapproved":
+			list.HideUnapproved = true
+		default:
+			return ErrIncorrect
+		}
+	}
+	return nil
+}
+
+// cursor reads the cursor param of keyset paginated lists
+func cursor(c *gin.Context, list *Page) error {
+	token := c.Query("cursor")
+	if token == "" {
+		return nil
+	}
+	after, err := parseCursor(token)
+	list.After = after
+	return err
+}
+
+// listResponse wraps a page of count items, a full page also gets the
+// next_cursor to continue after its last item
+func listResponse(response interface{}, list Page, count int, last func() Cursor) gin.H {
+	h := gin.H{"code": 0, "response": response}
+	if list.Limit > 0 && count == list.Limit {
+		h["next_cursor"] = last().String()
+	}
+	return h
+}
+
+// checkVote validates a vote of user, 1 is a like, -1 a dislike and 0 retracts
+// the vote. A vote without user is anonymous and is not recorded, so it can
+// be neither changed nor retracted.
+func checkVote(user string, vote int) error {
+	if vote < -1 || vote > 1 || user == "" && vote == 0 {
+		return ErrIncorrect
+	}
+	return nil
+}
+
+// required reports ErrInvalid when one of the mandatory fields is empty
+func required(values ...string) error {
+	for _, value := range values {
+		if value == "" {
+			return ErrInvalid
 		}
 	}
-	return rel
+	return nil
 }
 
+// commonClear wipes every table, it is meant for test runs and is refused
+// when the API requires authentication
 func (db *DB) commonClear(c *gin.Context) {
-	tables := []string{"forum", "post", "user", "thread", "follow", "subscription"}
-	for _, table := range tables {
-		db.Map.Exec(`truncate table ` + table)
+	if db.Auth {
+		fail(c, ErrUnauthorized)
+		return
+	}
+	if err := db.Store.Clear(); err != nil {
+		fail(c, err)
+		return
 	}
+	db.Cache.Purge()
 	c.JSON(http.StatusOK, gin.H{"code": 0, "response": "OK"})
 }
 
 func (db *DB) commonStatus(c *gin.Context) {
-	tables := []string{"forum", "post", "user", "thread"}
+	status, err := db.Store.Status()
+	if err != nil {
+		fail(c, err)
+		return
+	}
 	response := gin.H{}
-	for _, table := range tables {
-		count, _ := db.Map.SelectInt(`select count(*) from ` + table)
+	for table, count := range status {
 		response[table] = count
 	}
+	hits, misses := db.Cache.Stats()
+	response["cache"] = gin.H{"hits": hits, "misses": misses}
 	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
 }
 
+// commonSearch finds posts and threads by words of their text, the most relevant first
+func (db *DB) commonSearch(c *gin.Context) {
+	query := SearchQuery{Text: c.Query("query"), Forum: c.Query("forum"), User: c.Query("user"),
+		Since: c.Query("since"), Until: c.Query("until")}
+	if len(searchTerms(query.Text)) == 0 {
+		fail(c, ErrInvalid)
+		return
+	}
+	var err error
+	if query.Deleted, err = boolQuery(c, "deleted"); err != nil {
+		fail(c, err)
+		return
+	}
+	if query.Spam, err = boolQuery(c, "spam"); err != nil {
+		fail(c, err)
+		return
+	}
+	list, err := page(c, "since")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	query.Limit = list.Limit
+	if offset := c.Query("offset"); offset != "" {
+		if query.Offset, err = strconv.Atoi(offset); err != nil || query.Offset < 0 {
+			fail(c, ErrIncorrect)
+			return
+		}
+	}
+	results, err := db.Store.Search(query)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": results})
+}
+
 // FORUM METHODS
-func (db *DB) forumSelect(shortName string, full bool) gin.H {
-	forum := Forum{}
-	db.Map.SelectOne(&forum, "select * from forum where short_name = ?", shortName)
-	response := gin.H{"id": forum.ID, "name": forum.Name, "short_name": forum.ShortName, "user": forum.User}
+func (db *DB) forumSelect(shortName string, full bool) (gin.H, error) {
+	response, ok := db.Cache.Get(forumKey(shortName))
+	if !ok {
+		forum, err := db.Store.Forum(shortName)
+		if err != nil {
+			return nil, err
+		}
+		response = gin.H{"id": forum.ID, "name": forum.Name, "short_name": forum.ShortName, "user": forum.User,
+			"isDeleted": forum.IsDeleted, "moderatorsPostClosed": forum.ModeratorsPostClosed}
+		db.Cache.Set(forumKey(shortName), response)
+	}
 	if full {
-		response["user"] = db.userSelect(forum.User)
+		user, err := db.userSelect(response["user"].(string))
+		if err != nil {
+			return nil, err
+		}
+		response["user"] = user
 	}
-	return response
+	return response, nil
 }
 
 func (db *DB) forumCreate(c *gin.Context) {
 	forum := Forum{}
-	c.BindJSON(&forum)
-	db.Map.Exec("insert into forum (name, short_name, user) values(?, ?, ?)", forum.Name, forum.ShortName, forum.User)
-	response := db.forumSelect(forum.ShortName, false)
+	if err := parseBody(c, &forum); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &forum.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := required(forum.Name, forum.ShortName, forum.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.User(forum.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.CreateForum(&forum); err != nil {
+		fail(c, err)
+		return
+	}
+	response, err := db.forumSelect(forum.ShortName, false)
+	if err != nil {
+		fail(c, err)
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
 }
 
 func (db *DB) forumDetails(c *gin.Context) {
 	forum := c.Query("forum")
-	response := gin.H{}
-	if related := c.Query("related"); related == "user" {
-		response = db.forumSelect(forum, true)
-	} else {
-		response = db.forumSelect(forum, false)
+	if err := required(forum); err != nil {
+		fail(c, err)
+		return
+	}
+	related := c.Query("related")
+	if related != "" && related != "user" {
+		fail(c, ErrIncorrect)
+		return
+	}
+	response, err := db.forumSelect(forum, related == "user")
+	if err != nil {
+		fail(c, err)
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
 }
 
 func (db *DB) forumListPosts(c *gin.Context) {
 	entity := c.Request.URL.Query()["related"]
-	rel := relate(entity)
+	rel, err := relate(entity)
+	if err != nil {
+		fail(c, err)
+		return
+	}
 	shortName := c.Query("forum")
-	since := c.Query("since")
+	forum, err := db.forumSelect(shortName, false)
+	if err != nil {
+		fail(c, err)
+		return
+	}
 
-	query := "select * from post where forum = ?"
-	if since != "" {
-		query += " and date >= ?"
+	list, err := page(c, "since")
+	if err != nil {
+		fail(c, err)
+		return
 	}
-	query += " order by date " + c.DefaultQuery("order", "desc")
-	if limit := c.Query("limit"); limit != "" {
-		query += " limit " + limit
+	if err = hide(c, &list); err != nil {
+		fail(c, err)
+		return
 	}
-	posts := []Post{}
-	if since != "" {
-		db.Map.Select(&posts, query, shortName, since)
-	} else {
-		db.Map.Select(&posts, query, shortName)
+	if err = cursor(c, &list); err != nil {
+		fail(c, err)
+		return
 	}
-	forum := gin.H{}
-	if rel.Forum {
-		forum = db.forumSelect(shortName, false)
+	posts, err := db.Store.ForumPosts(shortName, list)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	related := newLoader(db.Store, db.Cache)
+	for _, post := range posts {
+		if rel.User {
+			related.AddUser(post.User)
+		}
+		if rel.Thread {
+			related.AddThread(post.Thread)
+		}
+	}
+	if err = related.Load(); err != nil {
+		fail(c, err)
+		return
 	}
 	response := make([]gin.H, len(posts))
 	for i, post := range posts {
-		response[i] = gin.H{"date": post.Date, "dislikes": post.Dislikes, "forum": post.Forum, "id": post.ID, "isApproved": post.IsApproved, "isDeleted": post.IsDeleted, "isEdited": post.IsEdited, "isHighlighted": post.IsHighlighted, "isSpam": post.IsSpam, "likes": post.Likes, "message": post.Message, "parent": post.Parent, "points": post.Points, "thread": post.Thread, "user": post.User}
+		response[i] = postResponse(post)
 		if rel.Forum {
 			response[i]["forum"] = forum
 		}
 		if rel.User {
-			response[i]["user"] = db.userSelect(response[i]["user"].(string))
+			if response[i]["user"], err = related.User(post.User); err != nil {
+				fail(c, err)
+				return
+			}
 		}
 		if rel.Thread {
-			response[i]["thread"] = db.threadSelect(response[i]["thread"].(int))
+			if response[i]["thread"], err = related.Thread(post.Thread); err != nil {
+				fail(c, err)
+				return
+			}
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
+	c.JSON(http.StatusOK, listResponse(response, list, len(posts), func() Cursor { return postCursor(posts[len(posts)-1], "flat") }))
 }
 
 func (db *DB) forumListThreads(c *gin.Context) {
 	entity := c.Request.URL.Query()["related"]
-	rel := relate(entity)
+	rel, err := relate(entity)
+	if err != nil || rel.Thread {
+		fail(c, ErrIncorrect)
+		return
+	}
 	shortName := c.Query("forum")
-	since := c.Query("since")
-	query := "select * from thread where forum = ?"
-	if since != "" {
-		query += " and date >= ?"
+	forum, err := db.forumSelect(shortName, false)
+	if err != nil {
+		fail(c, err)
+		return
 	}
-	query += " order by date " + c.DefaultQuery("order", "desc")
-	if limit := c.Query("limit"); limit != "" {
-		query += " limit " + limit
+
+	list, err := page(c, "since")
+	if err != nil {
+		fail(c, err)
+		return
 	}
-	threads := []Thread{}
-	if since != "" {
-		db.Map.Select(&threads, query, shortName, since)
-	} else {
-		db.Map.Select(&threads, query, shortName)
+	if err = cursor(c, &list); err != nil {
+		fail(c, err)
+		return
 	}
-	forum := gin.H{}
-	if rel.Forum {
-		forum = db.forumSelect(shortName, false)
+	threads, err := db.Store.ForumThreads(shortName, list)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	related := newLoader(db.Store, db.Cache)
+	if rel.User {
+		for _, thread := range threads {
+			related.AddUser(thread.User)
+		}
+	}
+	if err = related.Load(); err != nil {
+		fail(c, err)
+		return
 	}
 	response := make([]gin.H, len(threads))
 	for i, thread := range threads {
-		response[i] = gin.H{"date": thread.Date, "dislikes": thread.Dislikes, "forum": thread.Forum, "id": thread.ID, "isClosed": thread.IsClosed, "isDeleted": thread.IsDeleted, "likes": thread.Likes, "message": thread.Message, "points": thread.Points, "posts": thread.Posts, "slug": thread.Slug, "title": thread.Title, "user": thread.User}
+		response[i] = threadResponse(thread)
 		if rel.User {
-			response[i]["user"] = db.userSelect(response[i]["user"].(string))
+			if response[i]["user"], err = related.User(thread.User); err != nil {
+				fail(c, err)
+				return
+			}
 		}
 		if rel.Forum {
 			response[i]["forum"] = forum
 		}
 	}
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
+	c.JSON(http.StatusOK, listResponse(response, list, len(threads), func() Cursor { return threadCursor(threads[len(threads)-1]) }))
 }
 
 func (db *DB) forumListUsers(c *gin.Context) {
 	shortName := c.Query("forum")
-	since := c.Query("since_id")
-	query := "select * from user where email IN (select distinct user from post where forum = ?)"
-	if since != "" {
-		query += " and `user`.`id` >= ?"
+	if _, err := db.Store.Forum(shortName); err != nil {
+		fail(c, err)
+		return
 	}
-	query += " order by `user`.`name` " + c.DefaultQuery("order", "desc")
-	if limit := c.Query("limit"); limit != "" {
-		query += " limit " + limit
+
+	list, err := page(c, "since_id")
+	if err != nil {
+		fail(c, err)
+		return
 	}
-	users := []User{}
-	if since != "" {
-		db.Map.Select(&users, query, shortName, since)
-	} else {
-		db.Map.Select(&users, query, shortName)
+	if err = cursor(c, &list); err != nil {
+		fail(c, err)
+		return
+	}
+	users, err := db.Store.ForumUsers(shortName, list)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	related := newLoader(db.Store, db.Cache)
+	for _, user := range users {
+		related.AddUser(user.Email)
+	}
+	if err = related.Load(); err != nil {
+		fail(c, err)
+		return
 	}
-
 	response := make([]gin.H, len(users))
 	for i, user := range users {
-		var follower, following []string
-		var subs []int
-		db.Map.Select(&follower, "select follower from follow where following = ?", user.Email)
-		db.Map.Select(&following, "select following from follow where follower = ?", user.Email)
-		db.Map.Select(&subs, "select thread from subscription where user = ?", user.Email)
-
-		response[i] = gin.H{"about": user.About, "id": user.ID, "name": user.Name, "username": user.Username, "email": user.Email, "isAnonymous": user.IsAnonymous, "followers": follower, "following": following, "subscriptions": subs}
+		if response[i], err = related.User(user.Email); err != nil {
+			fail(c, err)
+			return
+		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
-}
-
-// THREAD METHODS
-func (db *DB) threadSelect(id int) gin.H {
-	thread := Thread{}
-	db.Map.SelectOne(&thread, "select * from thread where id = ?", id)
-	return gin.H{"date": thread.Date, "forum": thread.Forum, "id": thread.ID, "isClosed": thread.IsClosed, "isDeleted": thread.IsDeleted, "message": thread.Message, "slug": thread.Slug, "title": thread.Title, "user": thread.User, "posts": thread.Posts, "likes": thread.Likes, "dislikes": thread.Dislikes, "points": thread.Points}
-}
-
-func (db *DB) threadCreate(c *gin.Context) {
-	thread := Thread{}
-	c.BindJSON(&thread)
-	result, _ := db.Map.Exec("insert into thread (forum, user, title, isClosed, slug, date, message, IsDeleted) values (?, ?, ?, ?, ?, ?, ?, ?)",
-		thread.Forum, thread.User, thread.Title, thread.IsClosed, thread.Slug, thread.Date, thread.Message, thread.IsDeleted)
-	id, _ := result.LastInsertId()
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": gin.H{"date": thread.Date, "forum": thread.Forum, "id": id, "isClosed": thread.IsClosed, "isDeleted": thread.IsDeleted, "message": thread.Message, "slug": thread.Slug, "title": thread.Title, "user": thread.User}})
+	c.JSON(http.StatusOK, listResponse(response, list, len(users), func() Cursor { return userCursor(users[len(users)-1]) }))
 }
 
-func (db *DB) threadDetails(c *gin.Context) {
-	id, _ := strconv.Atoi(c.Query("thread"))
-	thread := db.threadSelect(id)
-	entity := c.Request.URL.Query()["related"]
-	rel := relate(entity)
-
-	if rel.Thread {
-		c.JSON(http.StatusOK, gin.H{"code": 3, "response": "Bad request"})
+// forumModerationQueue lists posts of a forum that are not approved or marked as spam
+func (db *DB) forumModerationQueue(c *gin.Context) {
+	shortName := c.Query("forum")
+	if _, err := db.Store.Forum(shortName); err != nil {
+		fail(c, err)
 		return
 	}
-	if rel.User {
-		thread["user"] = db.userSelect(thread["user"].(string))
+	list, err := page(c, "since")
+	if err != nil {
+		fail(c, err)
+		return
 	}
-	if rel.Forum {
-		thread["forum"] = db.forumSelect(thread["forum"].(string), false)
+	if err = cursor(c, &list); err != nil {
+		fail(c, err)
+		return
 	}
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": thread})
-}
-
-func (db *DB) threadClose(c *gin.Context) {
-	var thread struct {
-		ID int `json:"thread"`
+	posts, err := db.Store.ModerationQueue(shortName, list)
+	if err != nil {
+		fail(c, err)
+		return
 	}
-	c.BindJSON(&thread)
-	db.Map.Exec("update thread set isClosed = true where id = ?", thread.ID)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": thread})
+	response := make([]gin.H, len(posts))
+	for i, post := range posts {
+		response[i] = postResponse(post)
+	}
+	c.JSON(http.StatusOK, listResponse(response, list, len(posts), func() Cursor { return postCursor(posts[len(posts)-1], "flat") }))
 }
 
-func (db *DB) threadList(c *gin.Context) {
-	query := "select * from thread where "
-	if related := c.Query("forum"); related != "" {
-		query += "forum = " + "\"" + related + "\""
-	} else if related = c.Query("user"); related != "" {
-		query += "user = " + "\"" + related + "\""
+func (db *DB) forumUpdatePolicy(c *gin.Context) {
+	var policy struct {
+		Forum                string `json:"forum"`
+		ModeratorsPostClosed bool   `json:"moderatorsPostClosed"`
 	}
-	if since := c.Query("since"); since != "" {
-		query += " and date >= " + "\"" + since + "\""
+	if err := parseBody(c, &policy); err != nil {
+		fail(c, err)
+		return
 	}
-	if order := c.DefaultQuery("order", "desc"); order != "" {
-		query += " order by date " + order
+	if err := db.checkOwner(c, policy.Forum); err != nil {
+		fail(c, err)
+		return
 	}
-	if limit := c.Query("limit"); limit != "" {
-		query += " limit " + limit
+	if _, err := db.Store.Forum(policy.Forum); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.UpdateForumPolicy(policy.Forum, policy.ModeratorsPostClosed); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(forumKey(policy.Forum))
+	response, err := db.forumSelect(policy.Forum, false)
+	if err != nil {
+		fail(c, err)
+		return
 	}
-	response := []Thread{}
-	db.Map.Select(&response, query)
 	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
 }
 
-func (db *DB) threadListPosts(c *gin.Context) {
-	posts := []Post{}
-	id := c.Query("thread")
-	query := "select * from post where thread = ?"
-	if since := c.Query("since"); since != "" {
-		query += " and date >= " + "\"" + since + "\""
+func (db *DB) forumUpdate(c *gin.Context) {
+	var update struct {
+		Forum string `json:"forum"`
+		Name  string `json:"name"`
+		User  string `json:"user"`
 	}
-	order := c.Query("order")
-	sort := c.Query("sort")
-	if sort != "parent_tree" {
-		if sort == "" || sort == "flat" {
-			query += " order by date " + c.DefaultQuery("order", "desc")
-			if limit := c.Query("limit"); limit != "" {
-				query += " limit " + limit
-			}
-		} else if sort == "tree" {
-			query += "order by first_path " + order + ", last_path asc "
-			if limit := c.Query("limit"); limit != "" {
-				query += " limit " + limit
-			}
-		}
-		db.Map.Select(&posts, query, id)
-		c.JSON(http.StatusOK, gin.H{"code": 0, "response": posts})
+	if err := parseBody(c, &update); err != nil {
+		fail(c, err)
+		return
 	}
-	if sort == "parent_tree" {
-		posts := []Post{}
-		response := []Post{}
-
-		query += "order by first_path asc, last_path asc"
-		limit, _ := strconv.Atoi(c.Query("limit"))
-		db.Map.Select(&posts, query, id)
-		firstPath := -1
-		counter := 0
-		for i := 0; i < len(posts); i++ {
-			if firstPath != posts[i].FirstPath {
-				firstPath = posts[i].FirstPath
-				counter++
-			}
-			if counter > limit {
-				break
-			}
-			response = append(response, posts[i])
-		}
-		c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
+	if err := db.checkOwner(c, update.Forum); err != nil {
+		fail(c, err)
+		return
 	}
-}
-
-func (db *DB) threadOpen(c *gin.Context) {
-	var thread struct {
-		ID int `json:"thread"`
+	forum, err := db.Store.Forum(update.Forum)
+	if err != nil {
+		fail(c, err)
+		return
 	}
-	c.BindJSON(&thread)
-	db.Map.Exec("update thread set isClosed = false where id = ?", thread.ID)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": thread})
-}
-
-func (db *DB) threadRemove(c *gin.Context) {
-	var thread struct {
-		ID int `json:"thread"`
+	if update.Name == "" {
+		update.Name = forum.Name
 	}
-	c.BindJSON(&thread)
-	db.Map.Exec("update thread set isDeleted = true, posts = 0 where id = ?", thread.ID)
-	db.Map.Exec("update post set isDeleted = true where thread = ?", thread.ID)
-
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": thread})
-}
-
-func (db *DB) threadRestore(c *gin.Context) {
-	var thread struct {
-		ID int `json:"thread"`
+	if update.User == "" {
+		update.User = forum.User
+	} else if _, err = db.Store.User(update.User); err != nil {
+		fail(c, err)
+		return
 	}
-	c.BindJSON(&thread)
-	posts, _ := db.Map.SelectInt("select count(id) from post where thread = ?", thread.ID)
-	db.Map.Exec("update thread set isDeleted = false, posts = ? where id = ?", posts, thread.ID)
-	db.Map.Exec("update post set isDeleted = false where thread = ?", thread.ID)
-
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": thread})
+	if err = db.Store.UpdateForum(update.Forum, update.Name, update.User); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(forumKey(update.Forum))
+	response, err := db.forumSelect(update.Forum, false)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
 }
 
-func (db *DB) threadSubscribe(c *gin.Context) {
-	var subs struct {
+// forumRename changes short_name of a forum, threads and posts refer to it by short_name
+// so cached threads are dropped as well
+func (db *DB) forumRename(c *gin.Context) {
+	var rename struct {
+		Forum     string `json:"forum"`
+		ShortName string `json:"short_name"`
+	}
+	if err := parseBody(c, &rename); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.checkOwner(c, rename.Forum); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := required(rename.ShortName); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.Forum(rename.Forum); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.RenameForum(rename.Forum, rename.ShortName); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Purge()
+	response, err := db.forumSelect(rename.ShortName, false)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
+}
+
+func (db *DB) forumRemove(c *gin.Context) {
+	var forum struct {
+		Forum string `json:"forum"`
+	}
+	if err := parseBody(c, &forum); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.checkOwner(c, forum.Forum); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.Forum(forum.Forum); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.RemoveForum(forum.Forum); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Purge()
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": forum})
+}
+
+func (db *DB) forumRestore(c *gin.Context) {
+	var forum struct {
+		Forum string `json:"forum"`
+	}
+	if err := parseBody(c, &forum); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.checkOwner(c, forum.Forum); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.Forum(forum.Forum); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.RestoreForum(forum.Forum); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Purge()
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": forum})
+}
+
+// forumGrant gives target a role in a forum on behalf of user
+func (db *DB) forumGrant(c *gin.Context) {
+	var params struct {
+		Forum  string `json:"forum"`
+		User   string `json:"user"`
+		Target string `json:"target"`
+		Role   string `json:"role"`
+	}
+	if err := parseBody(c, &params); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &params.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := required(params.Forum, params.User, params.Target, params.Role); err != nil {
+		fail(c, err)
+		return
+	}
+	if params.Role != roleModerator && params.Role != roleMember && params.Role != roleBanned {
+		fail(c, ErrIncorrect)
+		return
+	}
+	if err := db.checkRoleChange(params.Forum, params.User, params.Target, params.Role); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.SetForumRole(params.Forum, params.Target, params.Role); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": gin.H{"forum": params.Forum, "user": params.Target, "role": params.Role}})
+}
+
+// forumRevoke makes target a plain member of a forum again on behalf of user
+func (db *DB) forumRevoke(c *gin.Context) {
+	var params struct {
+		Forum  string `json:"forum"`
+		User   string `json:"user"`
+		Target string `json:"target"`
+	}
+	if err := parseBody(c, &params); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &params.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := required(params.Forum, params.User, params.Target); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.checkRoleChange(params.Forum, params.User, params.Target, roleMember); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.RemoveForumRole(params.Forum, params.Target); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": gin.H{"forum": params.Forum, "user": params.Target, "role": roleMember}})
+}
+
+// checkRoleChange lets user give target a new role. The role of the owner
+// never changes, only the owner appoints and dismisses moderators, any
+// moderator bans and unbans members.
+func (db *DB) checkRoleChange(shortName, user, target, role string) error {
+	forum, err := db.Store.Forum(shortName)
+	if err != nil {
+		return err
+	}
+	if _, err = db.Store.User(target); err != nil {
+		return err
+	}
+	if target == forum.User {
+		return ErrIncorrect
+	}
+	actor, err := db.role(forum, user)
+	if err != nil {
+		return err
+	}
+	current, err := db.role(forum, target)
+	if err != nil {
+		return err
+	}
+	if role == roleModerator || current == roleModerator {
+		if actor != roleOwner {
+			return ErrNotOwner
+		}
+		return nil
+	}
+	if actor != roleOwner && actor != roleModerator {
+		return ErrNotModerator
+	}
+	return nil
+}
+
+func (db *DB) forumListRoles(c *gin.Context) {
+	forum, err := db.Store.Forum(c.Query("forum"))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	roles, err := db.Store.ForumRoles(forum.ShortName)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	roles = append([]Role{{User: forum.User, Role: roleOwner}}, roles...)
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": roles})
+}
+
+// THREAD METHODS
+func threadResponse(thread Thread) gin.H {
+	return gin.H{"date": thread.Date, "dislikes": thread.Dislikes, "forum": thread.Forum, "id": thread.ID, "isClosed": thread.IsClosed, "isDeleted": thread.IsDeleted, "likes": thread.Likes, "message": thread.Message, "points": thread.Points, "posts": thread.Posts, "slug": thread.Slug, "title": thread.Title, "user": thread.User}
+}
+
+func (db *DB) threadSelect(id int) (gin.H, error) {
+	if response, ok := db.Cache.Get(threadKey(id)); ok {
+		return response, nil
+	}
+	thread, err := db.Store.Thread(id)
+	if err != nil {
+		return nil, err
+	}
+	response := threadResponse(thread)
+	db.Cache.Set(threadKey(id), response)
+	return response, nil
+}
+
+func (db *DB) threadCreate(c *gin.Context) {
+	thread := Thread{}
+	if err := parseBody(c, &thread); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &thread.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := required(thread.Forum, thread.Title, thread.User, thread.Date, thread.Message, thread.Slug); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.openForum(thread.Forum); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.User(thread.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.checkBanned(thread.Forum, thread.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.CreateThread(&thread); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": gin.H{"date": thread.Date, "forum": thread.Forum, "id": thread.ID, "isClosed": thread.IsClosed, "isDeleted": thread.IsDeleted, "message": thread.Message, "slug": thread.Slug, "title": thread.Title, "user": thread.User}})
+}
+
+func (db *DB) threadDetails(c *gin.Context) {
+	id, err := intQuery(c, "thread")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	entity := c.Request.URL.Query()["related"]
+	rel, err := relate(entity)
+	if err != nil || rel.Thread {
+		fail(c, ErrIncorrect)
+		return
+	}
+	thread, err := db.threadSelect(id)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	if rel.User {
+		if thread["user"], err = db.userSelect(thread["user"].(string)); err != nil {
+			fail(c, err)
+			return
+		}
+	}
+	if rel.Forum {
+		if thread["forum"], err = db.forumSelect(thread["forum"].(string), false); err != nil {
+			fail(c, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": thread})
+}
+
+func (db *DB) threadClose(c *gin.Context) {
+	var thread struct {
 		ID   int    `json:"thread"`
 		User string `json:"user"`
 	}
-	c.BindJSON(&subs)
-	db.Map.Exec("insert into subscription (user, thread) values (?, ?)", subs.User, subs.ID)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": subs})
+	if err := parseBody(c, &thread); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &thread.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.checkThreadModerator(thread.ID, thread.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.CloseThread(thread.ID, true); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(threadKey(thread.ID))
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": thread})
 }
 
-func (db *DB) threadUnsubscribe(c *gin.Context) {
-	var subs struct {
+func (db *DB) threadList(c *gin.Context) {
+	list, err := page(c, "since")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if err = cursor(c, &list); err != nil {
+		fail(c, err)
+		return
+	}
+	response := []Thread{}
+	if forum := c.Query("forum"); forum != "" {
+		if _, err = db.Store.Forum(forum); err == nil {
+			response, err = db.Store.ForumThreads(forum, list)
+		}
+	} else if user := c.Query("user"); user != "" {
+		if _, err = db.Store.User(user); err == nil {
+			response, err = db.Store.UserThreads(user, list)
+		}
+	} else {
+		err = ErrInvalid
+	}
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse(response, list, len(response), func() Cursor { return threadCursor(response[len(response)-1]) }))
+}
+
+func (db *DB) threadListPosts(c *gin.Context) {
+	id, err := intQuery(c, "thread")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	sort := c.Query("sort")
+	if sort != "" && sort != "flat" && sort != "tree" && sort != "parent_tree" {
+		fail(c, ErrIncorrect)
+		return
+	}
+	format := c.Query("format")
+	if format != "" && format != "flat" && format != "nested" {
+		fail(c, ErrIncorrect)
+		return
+	}
+	// only tree sorts keep replies next to their parents
+	if format == "nested" && sort != "tree" && sort != "parent_tree" {
+		fail(c, ErrIncorrect)
+		return
+	}
+	maxDepth := -1
+	if depth := c.Query("maxDepth"); depth != "" {
+		if maxDepth, err = strconv.Atoi(depth); err != nil || maxDepth < 0 || format != "nested" {
+			fail(c, ErrIncorrect)
+			return
+		}
+	}
+	if _, err = db.Store.Thread(id); err != nil {
+		fail(c, err)
+		return
+	}
+	posts, err := page(c, "since")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if err = hide(c, &posts); err != nil {
+		fail(c, err)
+		return
+	}
+	if err = cursor(c, &posts); err != nil {
+		fail(c, err)
+		return
+	}
+	if (sort == "tree" || sort == "parent_tree") && c.Query("order") == "" {
+		posts.Order = "asc"
+	}
+	response, err := db.Store.ThreadPosts(id, sort, posts)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	// parent_tree pages count root posts
+	count := len(response)
+	if sort == "parent_tree" {
+		count = countRoots(response)
+	}
+	last := func() Cursor { return postCursor(response[len(response)-1], sort) }
+	if format == "nested" {
+		c.JSON(http.StatusOK, listResponse(nestPosts(response, maxDepth, postResponse), posts, count, last))
+		return
+	}
+	c.JSON(http.StatusOK, listResponse(response, posts, count, last))
+}
+
+// postNode is a post with its replies
+type postNode struct {
+	post     Post
+	children []*postNode
+}
+
+// replies counts all replies below the node
+func (n *postNode) replies() int {
+	count := len(n.children)
+	for _, child := range n.children {
+		count += child.replies()
+	}
+	return count
+}
+
+// nestPosts arranges posts sorted in tree order as trees, posts whose parent
+// is not on the page become roots. Depths are the levels of posts in the
+// thread, replies deeper than maxDepth are left out and counted in
+// moreReplies of their ancestor, a negative maxDepth keeps all. respond
+// renders a single post.
+func nestPosts(posts []Post, maxDepth int, respond func(Post) gin.H) []gin.H {
+	nodes := map[int]*postNode{}
+	roots := []*postNode{}
+	for _, post := range posts {
+		node := &postNode{post: post}
+		nodes[post.ID] = node
+		if parent, ok := nodes[parentID(&post)]; ok {
+			parent.children = append(parent.children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return nestedResponse(roots, maxDepth, respond)
+}
+
+func nestedResponse(nodes []*postNode, maxDepth int, respond func(Post) gin.H) []gin.H {
+	response := make([]gin.H, len(nodes))
+	for i, node := range nodes {
+		response[i] = respond(node.post)
+		response[i]["depth"] = node.post.Depth
+		if maxDepth >= 0 && node.post.Depth >= maxDepth && len(node.children) > 0 {
+			response[i]["children"] = []gin.H{}
+			response[i]["moreReplies"] = node.replies()
+		} else {
+			response[i]["children"] = nestedResponse(node.children, maxDepth, respond)
+		}
+	}
+	return response
+}
+
+func (db *DB) threadOpen(c *gin.Context) {
+	var thread struct {
 		ID   int    `json:"thread"`
 		User string `json:"user"`
 	}
-	c.BindJSON(&subs)
-	db.Map.Exec("delete from subscription where user = ? and thread = ?", subs.User, subs.ID)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": subs})
+	if err := parseBody(c, &thread); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &thread.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.checkThreadModerator(thread.ID, thread.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.CloseThread(thread.ID, false); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(threadKey(thread.ID))
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": thread})
 }
 
-func (db *DB) threadUpdate(c *gin.Context) {
-	type Update struct {
-		Message string `json:"message"`
-		Slug    string `json:"slug"`
-		ID      int    `json:"thread"`
+func (db *DB) threadRemove(c *gin.Context) {
+	var thread struct {
+		ID   int    `json:"thread"`
+		User string `json:"user"`
+	}
+	if err := parseBody(c, &thread); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &thread.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.checkThreadModerator(thread.ID, thread.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.RemoveThread(thread.ID); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(threadKey(thread.ID))
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": thread})
+}
+
+func (db *DB) threadRestore(c *gin.Context) {
+	var thread struct {
+		ID   int    `json:"thread"`
+		User string `json:"user"`
+	}
+	if err := parseBody(c, &thread); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &thread.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.checkThreadModerator(thread.ID, thread.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.RestoreThread(thread.ID); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(threadKey(thread.ID))
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": thread})
+}
+
+func (db *DB) threadSubscribe(c *gin.Context) {
+	var subs struct {
+		ID   int    `json:"thread"`
+		User string `json:"user"`
+	}
+	if err := parseBody(c, &subs); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &subs.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.Thread(subs.ID); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.User(subs.User); err != nil {
+		fail(c, err)
+		return
+	}
+	// subscribing twice is not an error
+	if err := db.Store.Subscribe(subs.User, subs.ID); err != nil && err != ErrExists {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(userKey(subs.User))
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": subs})
+}
+
+func (db *DB) threadUnsubscribe(c *gin.Context) {
+	var subs struct {
+		ID   int    `json:"thread"`
+		User string `json:"user"`
+	}
+	if err := parseBody(c, &subs); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &subs.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.Thread(subs.ID); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.User(subs.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.Unsubscribe(subs.User, subs.ID); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(userKey(subs.User))
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": subs})
+}
+
+func (db *DB) threadUpdate(c *gin.Context) {
+	type Update struct {
+		Message string `json:"message"`
+		Slug    string `json:"slug"`
+		ID      int    `json:"thread"`
+		User    string `json:"user"`
+	}
+	update := Update{}
+	if err := parseBody(c, &update); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &update.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := required(update.Message, update.Slug); err != nil {
+		fail(c, err)
+		return
+	}
+	db.editThread(c, update.ID, update.Message, update.Slug, update.User)
+}
+
+// editThread replaces message and slug of a thread on behalf of user, the
+// thread author when user is empty
+func (db *DB) editThread(c *gin.Context, id int, message, slug, user string) {
+	stored, err := db.Store.Thread(id)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if user, err = db.editor(stored.Forum, user, stored.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err = db.Store.UpdateThread(id, message, slug, user, time.Now().Format(dateFormat)); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(threadKey(id))
+
+	thread, err := db.threadSelect(id)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": thread})
+}
+
+func (db *DB) threadHistory(c *gin.Context) {
+	id, err := intQuery(c, "thread")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err = db.Store.Thread(id); err != nil {
+		fail(c, err)
+		return
+	}
+	revisions, err := db.Store.ThreadRevisions(id)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": revisions})
+}
+
+// threadRevert brings back message and slug of a revision, the current ones
+// become a revision themselves
+func (db *DB) threadRevert(c *gin.Context) {
+	var params struct {
+		ID       int    `json:"thread"`
+		Revision int    `json:"revision"`
+		User     string `json:"user"`
+	}
+	if err := parseBody(c, &params); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &params.User); err != nil {
+		fail(c, err)
+		return
+	}
+	revisions, err := db.Store.ThreadRevisions(params.ID)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	revision, err := findRevision(revisions, params.Revision)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	db.editThread(c, params.ID, revision.Message, revision.Slug, params.User)
+}
+
+func (db *DB) threadVote(c *gin.Context) {
+	type Thread struct {
+		Vote int    `json:"vote"`
+		ID   int    `json:"thread"`
+		User string `json:"user"`
+	}
+	thread := Thread{}
+	if err := parseBody(c, &thread); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &thread.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := checkVote(thread.User, thread.Vote); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.Thread(thread.ID); err != nil {
+		fail(c, err)
+		return
+	}
+	if thread.User != "" {
+		if _, err := db.Store.User(thread.User); err != nil {
+			fail(c, err)
+			return
+		}
+	}
+	if err := db.Store.VoteThread(thread.ID, thread.User, thread.Vote); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(threadKey(thread.ID))
+	response, err := db.threadSelect(thread.ID)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
+}
+
+func (db *DB) threadListVoters(c *gin.Context) {
+	id, err := intQuery(c, "thread")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err = db.Store.Thread(id); err != nil {
+		fail(c, err)
+		return
+	}
+	votes, err := db.Store.ThreadVotes(id)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": votes})
+}
+
+// POST METHODS
+// role returns the role of email in forum
+func (db *DB) role(forum Forum, email string) (string, error) {
+	if forum.User == email {
+		return roleOwner, nil
+	}
+	role, err := db.Store.ForumRole(forum.ShortName, email)
+	if err != nil || role == "" {
+		return roleMember, err
+	}
+	return role, nil
+}
+
+func (db *DB) isModerator(forum Forum, email string) (bool, error) {
+	role, err := db.role(forum, email)
+	return role == roleOwner || role == roleModerator, err
+}
+
+// checkModerator rejects users who do not moderate the forum. In open mode a
+// request without user is trusted as it was before forum roles.
+func (db *DB) checkModerator(shortName, user string) error {
+	forum, err := db.Store.Forum(shortName)
+	if err != nil {
+		return err
+	}
+	if user == "" && !db.Auth {
+		return nil
+	}
+	if err = required(user); err != nil {
+		return err
+	}
+	moderator, err := db.isModerator(forum, user)
+	if err != nil {
+		return err
+	}
+	if !moderator {
+		return ErrNotModerator
+	}
+	return nil
+}
+
+// checkThreadModerator rejects users who do not moderate the forum of a thread
+func (db *DB) checkThreadModerator(id int, user string) error {
+	thread, err := db.Store.Thread(id)
+	if err != nil {
+		return err
+	}
+	return db.checkModerator(thread.Forum, user)
+}
+
+// checkBanned rejects users banned in the forum
+func (db *DB) checkBanned(shortName, user string) error {
+	forum, err := db.Store.Forum(shortName)
+	if err != nil {
+		return err
+	}
+	role, err := db.role(forum, user)
+	if err != nil {
+		return err
+	}
+	if role == roleBanned {
+		return ErrBanned
+	}
+	return nil
+}
+
+// openForum returns a forum that takes new threads and posts, a removed one
+// is not found
+func (db *DB) openForum(shortName string) (Forum, error) {
+	forum, err := db.Store.Forum(shortName)
+	if err == nil && forum.IsDeleted {
+		err = ErrNotFound
+	}
+	return forum, err
+}
+
+// checkPostReferences makes sure the user, forum, thread and parent of a new
+// post exist and that the thread is in the forum and the parent in the thread
+func (db *DB) checkPostReferences(post Post) error {
+	if _, err := db.Store.User(post.User); err != nil {
+		return err
+	}
+	if _, err := db.openForum(post.Forum); err != nil {
+		return err
+	}
+	thread, err := db.Store.Thread(post.Thread)
+	if err != nil {
+		return err
+	}
+	if thread.Forum != post.Forum {
+		return ErrIncorrect
+	}
+	if post.Parent != nil {
+		parent, err := db.Store.Post(*post.Parent)
+		if err != nil {
+			return err
+		}
+		if parent.Thread != post.Thread {
+			return ErrIncorrect
+		}
+	}
+	return nil
+}
+
+// checkThreadWrite rejects writes of user to posts of a deleted thread and of a
+// closed one, unless the forum lets its moderators post in closed threads
+func (db *DB) checkThreadWrite(id int, user string) error {
+	thread, err := db.Store.Thread(id)
+	if err != nil {
+		return err
+	}
+	if thread.IsDeleted {
+		return ErrThreadDeleted
+	}
+	if !thread.IsClosed {
+		return nil
+	}
+	forum, err := db.Store.Forum(thread.Forum)
+	if err != nil {
+		return err
+	}
+	if !forum.ModeratorsPostClosed {
+		return ErrThreadClosed
+	}
+	moderator, err := db.isModerator(forum, user)
+	if err != nil {
+		return err
+	}
+	if !moderator {
+		return ErrThreadClosed
+	}
+	return nil
+}
+
+// editor returns who edits a post or a thread of a forum, the owner unless
+// user is given. Only the author and the moderators of the forum may edit.
+func (db *DB) editor(shortName, user, owner string) (string, error) {
+	if user == "" || user == owner {
+		return owner, nil
+	}
+	if _, err := db.Store.User(user); err != nil {
+		return "", err
+	}
+	forum, err := db.Store.Forum(shortName)
+	if err != nil {
+		return "", err
+	}
+	moderator, err := db.isModerator(forum, user)
+	if err != nil {
+		return "", err
+	}
+	if !moderator {
+		return "", ErrNotAuthor
+	}
+	return user, nil
+}
+
+// findRevision picks the revision with id, ErrNotFound if there is none
+func findRevision(revisions []Revision, id int) (Revision, error) {
+	for _, revision := range revisions {
+		if revision.ID == id {
+			return revision, nil
+		}
+	}
+	return Revision{}, ErrNotFound
+}
+
+func postResponse(post Post) gin.H {
+	return gin.H{"date": post.Date, "dislikes": post.Dislikes, "forum": post.Forum, "id": post.ID,
+		"isApproved": post.IsApproved, "isDeleted": post.IsDeleted, "isEdited": post.IsEdited,
+		"isHighlighted": post.IsHighlighted, "isSpam": post.IsSpam, "likes": post.Likes, "message": post.Message,
+		"parent": post.Parent, "points": post.Points, "thread": post.Thread, "user": post.User}
+}
+
+func (db *DB) postSelect(id int) (gin.H, error) {
+	post, err := db.Store.Post(id)
+	if err != nil {
+		return nil, err
+	}
+	response := postResponse(post)
+	response["first_path"] = 0
+	response["last_path"] = ""
+	return response, nil
+}
+
+func (db *DB) postCreate(c *gin.Context) {
+	post := Post{}
+	if err := parseBody(c, &post); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &post.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := required(post.Date, post.Forum, post.Message, post.User); err != nil || post.Thread == 0 {
+		fail(c, ErrInvalid)
+		return
+	}
+	if err := db.checkPostReferences(post); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.checkBanned(post.Forum, post.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.checkThreadWrite(post.Thread, post.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.CreatePost(&post); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(threadKey(post.Thread))
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": gin.H{"date": post.Date, "forum": post.Forum,
+		"id": post.ID, "isApproved": post.IsApproved, "isDeleted": post.IsDeleted, "isEdited": post.IsEdited,
+		"isHighlighted": post.IsHighlighted, "isSpam": post.IsSpam, "message": post.Message,
+		"parent": post.Parent, "thread": post.Thread, "user": post.User}})
+}
+
+func (db *DB) postDetails(c *gin.Context) {
+	post, err := intQuery(c, "post")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	entity := c.Request.URL.Query()["related"]
+	rel, err := relate(entity)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	response, err := db.postSelect(post)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if rel.User {
+		if response["user"], err = db.userSelect(response["user"].(string)); err != nil {
+			fail(c, err)
+			return
+		}
+	}
+	if rel.Thread {
+		if response["thread"], err = db.threadSelect(response["thread"].(int)); err != nil {
+			fail(c, err)
+			return
+		}
+	}
+	if rel.Thread {
+		if response["forum"], err = db.forumSelect(response["forum"].(string), false); err != nil {
+			fail(c, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
+}
+
+func (db *DB) postList(c *gin.Context) {
+	list, err := page(c, "since")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if err = cursor(c, &list); err != nil {
+		fail(c, err)
+		return
+	}
+	var posts []Post
+	if forum := c.Query("forum"); forum != "" {
+		if _, err = db.Store.Forum(forum); err == nil {
+			posts, err = db.Store.ForumPosts(forum, list)
+		}
+	} else if c.Query("thread") != "" {
+		var id int
+		if id, err = intQuery(c, "thread"); err == nil {
+			if _, err = db.Store.Thread(id); err == nil {
+				posts, err = db.Store.ThreadPosts(id, "flat", list)
+			}
+		}
+	} else {
+		err = ErrInvalid
+	}
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse(posts, list, len(posts), func() Cursor { return postCursor(posts[len(posts)-1], "flat") }))
+}
+
+// postReplies lists the subtree below a post in tree order, flat or nested.
+// depth limits how many levels of replies are listed, 1 keeps direct ones.
+func (db *DB) postReplies(c *gin.Context) {
+	id, err := intQuery(c, "post")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	entity := c.Request.URL.Query()["related"]
+	rel, err := relate(entity)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	format := c.Query("format")
+	if format != "" && format != "flat" && format != "nested" {
+		fail(c, ErrIncorrect)
+		return
+	}
+	depth := 0
+	if value := c.Query("depth"); value != "" {
+		if depth, err = strconv.Atoi(value); err != nil || depth <= 0 {
+			fail(c, ErrIncorrect)
+			return
+		}
+	}
+	list, err := page(c, "since")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if err = hide(c, &list); err != nil {
+		fail(c, err)
+		return
+	}
+	if err = cursor(c, &list); err != nil {
+		fail(c, err)
+		return
+	}
+	posts, err := db.Store.PostReplies(id, depth, list)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	related := newLoader(db.Store, db.Cache)
+	forums := map[string]gin.H{}
+	for _, post := range posts {
+		if rel.User {
+			related.AddUser(post.User)
+		}
+		if rel.Thread {
+			related.AddThread(post.Thread)
+		}
+		if rel.Forum && forums[post.Forum] == nil {
+			if forums[post.Forum], err = db.forumSelect(post.Forum, false); err != nil {
+				fail(c, err)
+				return
+			}
+		}
+	}
+	if err = related.Load(); err != nil {
+		fail(c, err)
+		return
+	}
+	responses := make(map[int]gin.H, len(posts))
+	response := make([]gin.H, len(posts))
+	for i, post := range posts {
+		response[i] = postResponse(post)
+		if rel.Forum {
+			response[i]["forum"] = forums[post.Forum]
+		}
+		if rel.User {
+			if response[i]["user"], err = related.User(post.User); err != nil {
+				fail(c, err)
+				return
+			}
+		}
+		if rel.Thread {
+			if response[i]["thread"], err = related.Thread(post.Thread); err != nil {
+				fail(c, err)
+				return
+			}
+		}
+		responses[post.ID] = response[i]
+	}
+
+	last := func() Cursor { return postCursor(posts[len(posts)-1], "tree") }
+	if format == "nested" {
+		c.JSON(http.StatusOK, listResponse(nestPosts(posts, -1, func(post Post) gin.H { return responses[post.ID] }), list, len(posts), last))
+		return
+	}
+	c.JSON(http.StatusOK, listResponse(response, list, len(posts), last))
+}
+
+func (db *DB) postRemove(c *gin.Context) {
+	var post struct {
+		ID   int    `json:"post"`
+		User string `json:"user"`
+	}
+	if err := parseBody(c, &post); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &post.User); err != nil {
+		fail(c, err)
+		return
+	}
+	stored, err := db.Store.Post(post.ID)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if err = db.checkModerator(stored.Forum, post.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err = db.Store.RemovePost(post.ID); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(threadKey(stored.Thread))
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": post})
+}
+
+func (db *DB) postRestore(c *gin.Context) {
+	var post struct {
+		ID   int    `json:"post"`
+		User string `json:"user"`
+	}
+	if err := parseBody(c, &post); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &post.User); err != nil {
+		fail(c, err)
+		return
+	}
+	stored, err := db.Store.Post(post.ID)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if err = db.checkModerator(stored.Forum, post.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err = db.checkThreadWrite(stored.Thread, post.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err = db.Store.RestorePost(post.ID); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(threadKey(stored.Thread))
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": post})
+}
+
+func (db *DB) postUpdate(c *gin.Context) {
+	var post struct {
+		ID      int    `json:"post"`
+		Message string `json:"message"`
+		User    string `json:"user"`
+	}
+	if err := parseBody(c, &post); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &post.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := required(post.Message); err != nil {
+		fail(c, err)
+		return
+	}
+	db.editPost(c, post.ID, post.Message, post.User)
+}
+
+// editPost replaces the message of a post on behalf of user, the post author
+// when user is empty
+func (db *DB) editPost(c *gin.Context, id int, message, user string) {
+	stored, err := db.Store.Post(id)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if user, err = db.editor(stored.Forum, user, stored.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err = db.checkThreadWrite(stored.Thread, user); err != nil {
+		fail(c, err)
+		return
+	}
+	if err = db.Store.UpdatePost(id, message, user, time.Now().Format(dateFormat)); err != nil {
+		fail(c, err)
+		return
+	}
+
+	postInfo, err := db.postSelect(id)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": postInfo})
+}
+
+func (db *DB) postHistory(c *gin.Context) {
+	id, err := intQuery(c, "post")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err = db.Store.Post(id); err != nil {
+		fail(c, err)
+		return
+	}
+	revisions, err := db.Store.PostRevisions(id)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": revisions})
+}
+
+// postRevert brings back the message of a revision, the current one becomes a
+// revision itself
+func (db *DB) postRevert(c *gin.Context) {
+	var params struct {
+		ID       int    `json:"post"`
+		Revision int    `json:"revision"`
+		User     string `json:"user"`
+	}
+	if err := parseBody(c, &params); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &params.User); err != nil {
+		fail(c, err)
+		return
 	}
-	update := Update{}
-	c.BindJSON(&update)
-	db.Map.Exec("update thread set message = ?, slug = ? where id = ?",
-		update.Message, update.Slug, update.ID)
-
-	thread := db.threadSelect(update.ID)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": thread})
+	revisions, err := db.Store.PostRevisions(params.ID)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	revision, err := findRevision(revisions, params.Revision)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	db.editPost(c, params.ID, revision.Message, params.User)
 }
 
-func (db *DB) threadVote(c *gin.Context) {
-	type Thread struct {
-		Vote int `json:"vote"`
-		ID   int `json:"thread"`
+func (db *DB) postVote(c *gin.Context) {
+	var post struct {
+		ID   int    `json:"post"`
+		Vote int    `json:"vote"`
+		User string `json:"user"`
 	}
-	thread := Thread{}
-	c.BindJSON(&thread)
-	if thread.Vote > 0 {
-		db.Map.Exec("update thread set likes = likes + 1, points = points + 1 where id = ?", thread.ID)
-	} else if thread.Vote < 0 {
-		db.Map.Exec("update thread set dislikes = dislikes + 1, points = points - 1 where id = ?", thread.ID)
+	if err := parseBody(c, &post); err != nil {
+		fail(c, err)
+		return
 	}
-	response := db.threadSelect(thread.ID)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
+	if err := db.identify(c, &post.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := checkVote(post.User, post.Vote); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.Post(post.ID); err != nil {
+		fail(c, err)
+		return
+	}
+	if post.User != "" {
+		if _, err := db.Store.User(post.User); err != nil {
+			fail(c, err)
+			return
+		}
+	}
+	if err := db.Store.VotePost(post.ID, post.User, post.Vote); err != nil {
+		fail(c, err)
+		return
+	}
+	postInfo, err := db.postSelect(post.ID)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": postInfo})
 }
 
-// POST METHODS
-const sizeOfPath int = 3
-
-func capacity(num int) int {
-	size := 0
-	for num > 0 {
-		num = num / 10
-		size++
+func (db *DB) postListVoters(c *gin.Context) {
+	id, err := intQuery(c, "post")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err = db.Store.Post(id); err != nil {
+		fail(c, err)
+		return
+	}
+	votes, err := db.Store.PostVotes(id)
+	if err != nil {
+		fail(c, err)
+		return
 	}
-	return size
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": votes})
 }
 
-func makePath(number int) string {
-	var mathPath string
-	for i := sizeOfPath - capacity(number); i > 0; i-- {
-		mathPath += "0"
-	}
-	str := strconv.Itoa(number)
-	mathPath += str
-	return mathPath
+func (db *DB) postApprove(c *gin.Context) {
+	db.moderatePost(c, db.Store.ApprovePost, true)
 }
 
-func (db *DB) postSelect(id int) gin.H {
-	post := Post{}
-	if err := db.Map.SelectOne(&post, "select * from post where id = ?", id); err == nil {
-		return gin.H{"date": post.Date, "dislikes": post.Dislikes, "forum": post.Forum, "id": post.ID,
-			"isApproved": post.IsApproved, "isDeleted": post.IsDeleted, "isEdited": post.IsEdited,
-			"isHighlighted": post.IsHighlighted, "isSpam": post.IsSpam, "likes": post.Likes, "message": post.Message,
-			"parent": post.Parent, "points": post.Points, "thread": post.Thread, "user": post.User, "first_path": 0, "last_path": ""}
-	}
-	return nil
+func (db *DB) postUnapprove(c *gin.Context) {
+	db.moderatePost(c, db.Store.ApprovePost, false)
 }
 
-func (db *DB) postCreate(c *gin.Context) {
-	post := Post{}
-	c.BindJSON(&post)
-	result, _ := db.Map.Exec("insert into post (date, forum, isApproved, isDeleted, isEdited, isHighlighted, isSpam, message, parent, thread, user) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		post.Date, post.Forum, post.IsApproved, post.IsDeleted, post.IsEdited, post.IsHighlighted,
-		post.IsSpam, post.Message, post.Parent, post.Thread, post.User)
-	id, _ := result.LastInsertId()
-
-	if post.Parent == nil {
-		db.Map.Exec("update post set first_path = ? where id = ?", id, id)
-	} else {
-		tempPost := Post{}
-		db.Map.SelectOne(&tempPost, "select first_path, last_path from post where id = ?", post.Parent)
-		firstPath := tempPost.FirstPath
-		lastPath := tempPost.LastPath
-		if lastPath == "" {
-			i := id
-			var i64 int
-			i64 = int(i)
-			mathPathID := "."
-			mathPathID += makePath(i64)
-			db.Map.Exec("update post set first_path = ?, last_path = ? where id = ?",
-				firstPath, mathPathID, id)
-		} else {
-			lastPath += "."
-			i := id
-			var i64 int
-			i64 = int(i)
-			mathPathID := makePath(i64)
-			lastPath += mathPathID
-			db.Map.Exec("update post set first_path = ?, last_path = ? where id = ?",
-				firstPath, lastPath, id)
-		}
-	}
-	db.Map.Exec("update thread set posts = posts + 1 where id = ?", post.Thread)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": gin.H{"date": post.Date, "forum": post.Forum,
-		"id": id, "isApproved": post.IsApproved, "isDeleted": post.IsDeleted, "isEdited": post.IsEdited,
-		"isHighlighted": post.IsHighlighted, "isSpam": post.IsSpam, "message": post.Message,
-		"parent": post.Parent, "thread": post.Thread, "user": post.User}})
+func (db *DB) postMarkSpam(c *gin.Context) {
+	db.moderatePost(c, db.Store.MarkSpam, true)
 }
 
-func (db *DB) postDetails(c *gin.Context) {
-	id := (c.Query("post"))
-	post, _ := strconv.Atoi(id)
+func (db *DB) postUnmarkSpam(c *gin.Context) {
+	db.moderatePost(c, db.Store.MarkSpam, false)
+}
 
-	entity := c.Request.URL.Query()["related"]
-	rel := relate(entity)
+func (db *DB) postHighlight(c *gin.Context) {
+	db.moderatePost(c, db.Store.HighlightPost, true)
+}
 
-	if response := db.postSelect(post); response != nil {
-		if rel.User {
-			response["user"] = db.userSelect(response["user"].(string))
-		}
-		if rel.Thread {
-			response["thread"] = db.threadSelect(response["thread"].(int))
-		}
-		if rel.Thread {
-			response["forum"] = db.forumSelect(response["forum"].(string), false)
-		}
-		c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
-	} else {
-		c.JSON(http.StatusOK, gin.H{"code": 1, "response": "Post not found"})
-	}
+func (db *DB) postUnhighlight(c *gin.Context) {
+	db.moderatePost(c, db.Store.HighlightPost, false)
 }
 
-func (db *DB) postList(c *gin.Context) {
-	forum := c.Query("forum")
-	thread := c.Query("thread")
-	since := c.Query("since")
-	query := "select * from post where "
-	if forum != "" {
-		query += "forum = ?"
-	} else if thread != "" {
-		query += "thread = ?"
-	}
-	if since != "" {
-		query += " and date >= ?"
-	}
-	query += " order by date " + c.DefaultQuery("order", "desc")
-	if limit := c.Query("limit"); limit != "" {
-		query += " limit " + limit
+// moderatePost sets a moderation flag of a post, only moderators of the forum may do it
+func (db *DB) moderatePost(c *gin.Context, set func(id int, value bool) error, value bool) {
+	var params struct {
+		ID   int    `json:"post"`
+		User string `json:"user"`
 	}
-	var posts []Post
-	if forum != "" {
-		if since != "" {
-			db.Map.Select(&posts, query, forum, since)
-		} else {
-			db.Map.Select(&posts, query, forum)
-		}
-	} else if thread != "" {
-		if since != "" {
-			db.Map.Select(&posts, query, thread, since)
-		} else {
-			db.Map.Select(&posts, query, thread)
-		}
+	if err := parseBody(c, &params); err != nil {
+		fail(c, err)
+		return
 	}
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": posts})
-}
-
-func (db *DB) postRemove(c *gin.Context) {
-	var post struct {
-		ID int `json:"post"`
+	if err := db.identify(c, &params.User); err != nil {
+		fail(c, err)
+		return
+	}
+	post, err := db.Store.Post(params.ID)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if err = db.checkModerator(post.Forum, params.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err = set(params.ID, value); err != nil {
+		fail(c, err)
+		return
 	}
-	c.BindJSON(&post)
-	db.Map.Exec("update post set isDeleted = true where id = ? ", post.ID)
-	thread, _ := db.Map.SelectInt("select thread from post where id = ?", post.ID)
-	db.Map.Exec("update thread set posts = posts - 1 where id = ?", thread)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": post})
 
+	postInfo, err := db.postSelect(params.ID)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": postInfo})
 }
 
-func (db *DB) postRestore(c *gin.Context) {
-	var post struct {
-		ID int `json:"post"`
+// USER METHODS
+func userResponse(user User, followers, following []string, subs []int) gin.H {
+	if followers == nil {
+		followers = []string{}
 	}
-	c.BindJSON(&post)
-	db.Map.Exec("update post set isDeleted = false where id = ? ", post.ID)
-	thread, _ := db.Map.SelectInt("select thread from post where id = ?", post.ID)
-	db.Map.Exec("update thread set posts = posts + 1 where id = ?", thread)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": post})
+	if following == nil {
+		following = []string{}
+	}
+	if subs == nil {
+		subs = []int{}
+	}
+	return gin.H{"about": user.About, "id": user.ID, "name": user.Name,
+		"username": user.Username, "email": user.Email, "isAnonymous": user.IsAnonymous, "followers": followers, "following": following, "subscriptions": subs}
 }
 
-func (db *DB) postUpdate(c *gin.Context) {
-	var post struct {
-		ID      int    `json:"post"`
-		Message string `json:"message"`
+func (db *DB) userSelect(email string) (gin.H, error) {
+	users := newLoader(db.Store, db.Cache)
+	users.AddUser(email)
+	if err := users.Load(); err != nil {
+		return nil, err
 	}
-	c.BindJSON(&post)
-	db.Map.Exec("update post set message = ? where id = ?", post.Message, post.ID)
-
-	postInfo := db.postSelect(post.ID)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": postInfo})
+	return users.User(email)
 }
 
-func (db *DB) postVote(c *gin.Context) {
-	var post struct {
-		ID   int `json:"post"`
-		Vote int `json:"vote"`
+func (db *DB) userCreate(c *gin.Context) {
+	var params struct {
+		User
+		Password string `json:"password"`
 	}
-	c.BindJSON(&post)
-	if post.Vote > 0 {
-		db.Map.Exec("update post set likes = likes + 1, points = points + 1 where id = ?", post.ID)
-	} else {
-		db.Map.Exec("update post set dislikes = dislikes + 1, points = points - 1 where id = ?", post.ID)
+	if err := parseBody(c, &params); err != nil {
+		fail(c, err)
+		return
 	}
-	postInfo := db.postSelect(post.ID)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": postInfo})
+	user := params.User
+	if err := required(user.Email); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.CreateUser(&user); err != nil {
+		fail(c, err)
+		return
+	}
+	if params.Password != "" {
+		hash, err := hashPassword(params.Password)
+		if err == nil {
+			err = db.Store.SetPassword(user.Email, hash)
+		}
+		if err != nil {
+			fail(c, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": gin.H{"about": user.About, "email": user.Email, "id": user.ID, "isAnonymous": user.IsAnonymous, "name": user.Name, "username": user.Username}})
 }
 
-// USER METHODS
-func (db *DB) userSelect(email string) gin.H {
-	user := User{}
-	var follower, following []string
-	var subs []int
-	db.Map.SelectOne(&user, "select * from user where email = ?", email)
-	db.Map.Select(&follower, "select follower from follow where following = ?", email)
-	db.Map.Select(&following, "select following from follow where follower = ?", email)
-	db.Map.Select(&subs, "select thread from subscription where user = ?", email)
-
-	response := gin.H{"about": user.About, "id": user.ID, "name": user.Name,
-		"username": user.Username, "email": user.Email, "isAnonymous": user.IsAnonymous, "followers": follower, "following": following, "subscriptions": subs}
-	return response
+// userLogin issues an API token to a user with a password
+func (db *DB) userLogin(c *gin.Context) {
+	var params struct {
+		User     string `json:"user"`
+		Password string `json:"password"`
+	}
+	if err := parseBody(c, &params); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := required(params.User, params.Password); err != nil {
+		fail(c, err)
+		return
+	}
+	hash, err := db.Store.Password(params.User)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if !checkPassword(hash, params.Password) {
+		fail(c, ErrUnauthorized)
+		return
+	}
+	token, err := newToken()
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	now := time.Now()
+	expires := now.Add(db.TokenTTL).Format(dateFormat)
+	if err = db.Store.CreateToken(tokenHash(token), params.User, now.Format(dateFormat), expires); err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": gin.H{"user": params.User, "token": token, "expires": expires}})
 }
 
-func (db *DB) userCreate(c *gin.Context) {
-	user := User{}
-	c.BindJSON(&user)
-	if result, err := db.Map.Exec("insert into user (about, name, username, isAnonymous, email) values(?, ?, ?, ?, ?)",
-		user.About, user.Name, user.Username, user.IsAnonymous, user.Email); err == nil {
-		id, _ := result.LastInsertId()
-		c.JSON(http.StatusOK, gin.H{"code": 0, "response": gin.H{"about": user.About, "email": user.Email, "id": id, "isAnonymous": user.IsAnonymous, "name": user.Name, "username": user.Username}})
-	} else {
-		c.JSON(http.StatusOK, gin.H{"code": 5, "response": "User already exists"})
+// userLogout revokes the token the request came with
+func (db *DB) userLogout(c *gin.Context) {
+	token, ok := c.Get(tokenKey)
+	if !ok {
+		fail(c, ErrUnauthorized)
+		return
+	}
+	if err := db.Store.RemoveToken(tokenHash(token.(string))); err != nil {
+		fail(c, err)
+		return
 	}
+	user, _ := c.Get(callerKey)
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": gin.H{"user": user}})
 }
 
 func (db *DB) userDetails(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": db.userSelect(c.Query("user"))})
+	response, err := db.userSelect(c.Query("user"))
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
 }
 
 func (db *DB) userFollow(c *gin.Context) {
 	fol := Follow{}
-	c.BindJSON(&fol)
-	db.Map.Exec("insert into follow (follower, following) values(?, ?)", fol.Follower, fol.Following)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": db.userSelect(fol.Follower)})
+	if err := parseBody(c, &fol); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &fol.Follower); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.User(fol.Following); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.User(fol.Follower); err != nil {
+		fail(c, err)
+		return
+	}
+	// following twice is not an error
+	if err := db.Store.Follow(fol.Follower, fol.Following); err != nil && err != ErrExists {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(userKey(fol.Follower), userKey(fol.Following))
+	response, err := db.userSelect(fol.Follower)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
 }
 
 func (db *DB) userFollowersList(c *gin.Context) {
 	user := c.Query("user")
-	limit := c.Query("limit")
-	since := c.Query("since_id")
-
-	query := "select follower from follow join user on follower = email where following = ? "
-	if since != "" {
-		query += "and `id` >= ? "
+	if _, err := db.Store.User(user); err != nil {
+		fail(c, err)
+		return
+	}
+	list, err := page(c, "since_id")
+	if err != nil {
+		fail(c, err)
+		return
 	}
-	query += " order by follower " + c.DefaultQuery("order", "desc")
-	if limit != "" {
-		query += " limit " + limit
+	if err = cursor(c, &list); err != nil {
+		fail(c, err)
+		return
 	}
-	var followers []string
-	if since != "" {
-		db.Map.Select(&followers, query, user, since)
-	} else {
-		db.Map.Select(&followers, query, user)
+	followers, err := db.Store.Followers(user, list)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	related := newLoader(db.Store, db.Cache)
+	for _, flw := range followers {
+		related.AddUser(flw)
+	}
+	if err = related.Load(); err != nil {
+		fail(c, err)
+		return
 	}
 	followList := make([]gin.H, len(followers))
 	for i, flw := range followers {
-		followList[i] = db.userSelect(flw)
+		if followList[i], err = related.User(flw); err != nil {
+			fail(c, err)
+			return
+		}
 	}
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": followList})
+	c.JSON(http.StatusOK, listResponse(followList, list, len(followers), func() Cursor { return Cursor{Key: followers[len(followers)-1]} }))
 }
 
 func (db *DB) userFollowingList(c *gin.Context) {
 	user := c.Query("user")
-	limit := c.Query("limit")
-	since := c.Query("since_id")
-	query := "select following from follow join user on following = email where follower = ? "
-	if since != "" {
-		query += "and `id` >= ? "
-	}
-	query += " order by following " + c.DefaultQuery("order", "desc")
-	if limit != "" {
-		query += " limit " + limit
-	}
-	var following []string
-	if since != "" {
-		db.Map.Select(&following, query, user, since)
-	} else {
-		db.Map.Select(&following, query, user)
+	if _, err := db.Store.User(user); err != nil {
+		fail(c, err)
+		return
+	}
+	list, err := page(c, "since_id")
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	if err = cursor(c, &list); err != nil {
+		fail(c, err)
+		return
+	}
+	following, err := db.Store.Following(user, list)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	related := newLoader(db.Store, db.Cache)
+	for _, flw := range following {
+		related.AddUser(flw)
+	}
+	if err = related.Load(); err != nil {
+		fail(c, err)
+		return
 	}
 	followList := make([]gin.H, len(following))
 	for i, flw := range following {
-		followList[i] = db.userSelect(flw)
+		if followList[i], err = related.User(flw); err != nil {
+			fail(c, err)
+			return
+		}
 	}
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": followList})
+	c.JSON(http.StatusOK, listResponse(followList, list, len(following), func() Cursor { return Cursor{Key: following[len(following)-1]} }))
 }
 
 func (db *DB) userUnfollow(c *gin.Context) {
 	unfol := Follow{}
-	c.BindJSON(&unfol)
-	db.Map.Exec("delete from follow where follower = ? and following = ?", unfol.Follower, unfol.Following)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": db.userSelect(unfol.Follower)})
+	if err := parseBody(c, &unfol); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &unfol.Follower); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.User(unfol.Following); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.User(unfol.Follower); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.Unfollow(unfol.Follower, unfol.Following); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(userKey(unfol.Follower), userKey(unfol.Following))
+	response, err := db.userSelect(unfol.Follower)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
 }
 
 func (db *DB) userListPosts(c *gin.Context) {
 	user := c.Query("user")
-	since := c.Query("since")
-	query := "select * from post where user = ?"
-	if since != "" {
-		query += " and date >= ?"
+	if _, err := db.Store.User(user); err != nil {
+		fail(c, err)
+		return
 	}
-	query += " order by date " + c.DefaultQuery("order", "desc")
-	if limit := c.Query("limit"); limit != "" {
-		query += " limit " + limit
+	list, err := page(c, "since")
+	if err != nil {
+		fail(c, err)
+		return
 	}
-	posts := []Post{}
-	if since != "" {
-		db.Map.Select(&posts, query, user, since)
-	} else {
-		db.Map.Select(&posts, query, user)
+	if err = cursor(c, &list); err != nil {
+		fail(c, err)
+		return
 	}
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": posts})
+	posts, err := db.Store.UserPosts(user, list)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse(posts, list, len(posts), func() Cursor { return postCursor(posts[len(posts)-1], "flat") }))
 }
 
 func (db *DB) userUpdate(c *gin.Context) {
 	params := UpdateUser{}
-	c.BindJSON(&params)
-	db.Map.Exec("update user set about = ?, name = ? where email = ?", params.About, params.Name, params.User)
-	c.JSON(http.StatusOK, gin.H{"code": 0, "response": db.userSelect(params.User)})
+	if err := parseBody(c, &params); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &params.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if _, err := db.Store.User(params.User); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.Store.UpdateUser(params.User, params.About, params.Name); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Delete(userKey(params.User))
+	response, err := db.userSelect(params.User)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": response})
+}
+
+// userRemove deletes an account, posts, threads and votes of the user stay but
+// belong to an anonymous account from now on. The account keeps its id under
+// an alias email, loses name, username and about and gets isAnonymous set, so
+// its posts show up like those of users who post anonymously. Owners of a
+// forum, even a removed one, are refused until they hand the forum over with
+// forum/update, a forum must always have somebody to manage it.
+func (db *DB) userRemove(c *gin.Context) {
+	var params struct {
+		User string `json:"user"`
+	}
+	if err := parseBody(c, &params); err != nil {
+		fail(c, err)
+		return
+	}
+	if err := db.identify(c, &params.User); err != nil {
+		fail(c, err)
+		return
+	}
+	user, err := db.Store.User(params.User)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+	alias := "deleted" + strconv.FormatInt(user.ID, 10) + "@anonymous"
+	if err = db.Store.RemoveUser(user.Email, alias); err != nil {
+		fail(c, err)
+		return
+	}
+	db.Cache.Purge()
+	c.JSON(http.StatusOK, gin.H{"code": 0, "response": gin.H{"user": user.Email, "alias": alias}})
+}
+
+// exportBatch is how many items of a section userExport loads at a time
+const exportBatch = 500
+
+// userExport writes everything stored about a user as one JSON document. Every
+// section is read in keyset pages of exportBatch and written as they come, so
+// large accounts are never held in memory at once. The status goes out before
+// the sections are read, so the code comes last: an error on the way closes
+// the document with its code and an error member instead of code 0.
+func (db *DB) userExport(c *gin.Context) {
+	email := c.Query("user")
+	if err := db.identify(c, &email); err != nil {
+		fail(c, err)
+		return
+	}
+	user, err := db.Store.User(email)
+	if err != nil {
+		fail(c, err)
+		return
+	}
+
+	sections := []struct {
+		Name string
+		Load func(emit func(item interface{}) error) error
+	}{
+		{"posts", func(emit func(item interface{}) error) error {
+			return exportPages(func(page Page) (int, Cursor, error) {
+				posts, err := db.Store.UserPosts(email, page)
+				if err != nil || len(posts) == 0 {
+					return 0, Cursor{}, err
+				}
+				for _, post := range posts {
+					if err = emit(post); err != nil {
+						return 0, Cursor{}, err
+					}
+				}
+				return len(posts), postCursor(posts[len(posts)-1], "flat"), nil
+			})
+		}},
+		{"threads", func(emit func(item interface{}) error) error {
+			return exportPages(func(page Page) (int, Cursor, error) {
+				threads, err := db.Store.UserThreads(email, page)
+				if err != nil || len(threads) == 0 {
+					return 0, Cursor{}, err
+				}
+				for _, thread := range threads {
+					if err = emit(thread); err != nil {
+						return 0, Cursor{}, err
+					}
+				}
+				return len(threads), threadCursor(threads[len(threads)-1]), nil
+			})
+		}},
+		{"votes", func(emit func(item interface{}) error) error {
+			for _, entity := range []string{"thread", "post"} {
+				err := exportPages(func(page Page) (int, Cursor, error) {
+					votes, err := db.Store.UserVotes(email, entity, page)
+					if err != nil || len(votes) == 0 {
+						return 0, Cursor{}, err
+					}
+					for _, vote := range votes {
+						if err = emit(vote); err != nil {
+							return 0, Cursor{}, err
+						}
+					}
+					last := votes[len(votes)-1]
+					return len(votes), Cursor{ID: last.Thread + last.Post}, nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"followers", func(emit func(item interface{}) error) error {
+			return exportPages(func(page Page) (int, Cursor, error) {
+				return exportEmails(db.Store.Followers, email, page, emit)
+			})
+		}},
+		{"following", func(emit func(item interface{}) error) error {
+			return exportPages(func(page Page) (int, Cursor, error) {
+				return exportEmails(db.Store.Following, email, page, emit)
+			})
+		}},
+		{"subscriptions", func(emit func(item interface{}) error) error {
+			return exportPages(func(page Page) (int, Cursor, error) {
+				subs, err := db.Store.UserSubscriptions(email, page)
+				if err != nil || len(subs) == 0 {
+					return 0, Cursor{}, err
+				}
+				for _, sub := range subs {
+					if err = emit(sub); err != nil {
+						return 0, Cursor{}, err
+					}
+				}
+				return len(subs), Cursor{ID: subs[len(subs)-1].Thread}, nil
+			})
+		}},
+	}
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="user.json"`)
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	io.WriteString(c.Writer, `{"response":{"profile":`)
+	encoder.Encode(user)
+	for _, section := range sections {
+		io.WriteString(c.Writer, `,"`+section.Name+`":[`)
+		first := true
+		err = section.Load(func(item interface{}) error {
+			if !first {
+				io.WriteString(c.Writer, ",")
+			}
+			first = false
+			return encoder.Encode(item)
+		})
+		io.WriteString(c.Writer, "]")
+		if err != nil {
+			break
+		}
+		c.Writer.Flush()
+	}
+	io.WriteString(c.Writer, "}")
+	if err != nil {
+		apiErr := apiError(err)
+		io.WriteString(c.Writer, `,"code":`+strconv.Itoa(apiErr.Code)+`,"error":`)
+		encoder.Encode(apiErr.Message)
+		io.WriteString(c.Writer, "}")
+		return
+	}
+	io.WriteString(c.Writer, `,"code":0}`)
+}
+
+// exportPages calls load with ascending pages of exportBatch items, each one
+// after the cursor load returned for the previous page, until a page is short
+func exportPages(load func(page Page) (int, Cursor, error)) error {
+	page := Page{Order: "asc", Limit: exportBatch}
+	for {
+		count, last, err := load(page)
+		if err != nil || count < exportBatch {
+			return err
+		}
+		page.After = &last
+	}
+}
+
+// exportEmails emits a page of followers or followed users of email
+func exportEmails(list func(email string, page Page) ([]string, error), email string, page Page, emit func(item interface{}) error) (int, Cursor, error) {
+	emails, err := list(email, page)
+	if err != nil || len(emails) == 0 {
+		return 0, Cursor{}, err
+	}
+	for _, item := range emails {
+		if err = emit(item); err != nil {
+			return 0, Cursor{}, err
+		}
+	}
+	return len(emails), Cursor{Key: emails[len(emails)-1]}, nil
 }
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-gorp/gorp"
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// stores are the backends every handler test runs against
+var stores = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store { return newMemoryStore() }},
+	{"sqlite3", openSQLite},
+}
+
+// openSQLite migrates a SQLite database in memory
+func openSQLite(t *testing.T) Store {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	dbmap := &gorp.DbMap{Db: db, Dialect: gorp.SqliteDialect{}}
+	migrations, err := newMigrator(dbmap, "sqlite3")
+	if err == nil {
+		err = migrations.Up(0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newSQLiteStore(dbmap)
+}
+
+// forEachStore runs test with a client of every store
+func forEachStore(t *testing.T, test func(t *testing.T, c client)) {
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			store := s.open(t)
+			defer store.Close()
+			test(t, newClient(t, store))
+		})
+	}
+}
+
+// client sends requests to the API router of db
+type client struct {
+	t  *testing.T
+	r  *gin.Engine
+	db *DB
+}
+
+// newClient serves the API from store
+func newClient(t *testing.T, store Store) client {
+	gin.SetMode(gin.TestMode)
+	db := &DB{Store: store, Cache: newCache(1000, time.Minute), TokenTTL: time.Hour}
+	return client{t, newRouter(db), db}
+}
+
+func (c client) store() Store { return c.db.Store }
+
+// do sends body as JSON with token as the bearer token when given, the
+// decoded response gets the HTTP status as _status
+func (c client) do(method, url, token string, body interface{}) map[string]interface{} {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, url, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	c.r.ServeHTTP(w, req)
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		c.t.Fatalf("%s %s: %v %q", method, url, err, w.Body.String())
+	}
+	out["_status"] = w.Code
+	return out
+}
+
+func (c client) get(url string) map[string]interface{} { return c.do("GET", url, "", nil) }
+
+func (c client) post(url string, body interface{}) map[string]interface{} {
+	return c.do("POST", url, "", body)
+}
+
+// seed creates users a@a and b@b, forum f of a@a and its thread 1
+func seed(c client) {
+	c.post("/db/api/user/create/", map[string]interface{}{"email": "a@a", "name": "A", "username": "a", "about": "x"})
+	c.post("/db/api/user/create/", map[string]interface{}{"email": "b@b", "name": "B", "username": "b", "about": "y"})
+	c.post("/db/api/forum/create/", map[string]interface{}{"name": "F", "short_name": "f", "user": "a@a"})
+	c.post("/db/api/thread/create/", map[string]interface{}{"forum": "f", "user": "a@a", "title": "T", "slug": "t",
+		"date": "2014-01-01 00:00:00", "message": "m", "isClosed": false, "isDeleted": false})
+}
+
+// createPost adds a post of a@a to thread 1 and returns its id
+func createPost(c client, date string, parent interface{}) int {
+	r := c.post("/db/api/post/create/", map[string]interface{}{"date": date, "thread": 1, "message": "p",
+		"user": "a@a", "forum": "f", "parent": parent})
+	return int(r["response"].(map[string]interface{})["id"].(float64))
+}
+
+func code(r map[string]interface{}) int {
+	if v, ok := r["code"].(float64); ok {
+		return int(v)
+	}
+	return -1
+}
+
+func expectCode(t *testing.T, name string, r map[string]interface{}, want int) {
+	t.Helper()
+	if code(r) != want || r["_status"] != 200 {
+		t.Errorf("%s: want code %d, got %v", name, want, r)
+	}
+}
+
+// field lists key of every item in the response list of r
+func field(t *testing.T, r map[string]interface{}, key string) string {
+	t.Helper()
+	list, ok := r["response"].([]interface{})
+	if !ok {
+		t.Fatal(r)
+	}
+	seen := []interface{}{}
+	for _, item := range list {
+		seen = append(seen, item.(map[string]interface{})[key])
+	}
+	return fmt.Sprint(seen)
+}
+
+// ids lists the post ids as field does
+func ids(list ...int) string {
+	out := make([]interface{}, len(list))
+	for i, id := range list {
+		out[i] = float64(id)
+	}
+	return fmt.Sprint(out)
+}
+
+// walk follows next_cursor from url and returns key of every listed item
+func walk(t *testing.T, c client, url, key string) string {
+	t.Helper()
+	seen := []interface{}{}
+	next := ""
+	for i := 0; i < 100; i++ {
+		u := url
+		if next != "" {
+			u += "&cursor=" + next
+		}
+		r := c.get(u)
+		if code(r) != 0 {
+			t.Fatal(u, r)
+		}
+		for _, item := range r["response"].([]interface{}) {
+			seen = append(seen, item.(map[string]interface{})[key])
+		}
+		n, ok := r["next_cursor"].(string)
+		if !ok {
+			return fmt.Sprint(seen)
+		}
+		next = n
+	}
+	t.Fatal("no last page", url)
+	return ""
+}
+
+func TestAPI(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c client) {
+		seed(c)
+		createPost(c, "2014-01-02 00:00:00", nil)
+		createPost(c, "2014-01-03 00:00:00", 1)
+		createPost(c, "2014-01-04 00:00:00", nil)
+
+		r := c.get("/db/api/post/details/?post=2&related=user")["response"].(map[string]interface{})
+		if r["parent"] != 1.0 || r["message"] != "p" || r["user"].(map[string]interface{})["email"] != "a@a" {
+			t.Error("post details", r)
+		}
+		if r := c.get("/db/api/thread/details/?thread=1")["response"].(map[string]interface{}); r["posts"] != 3.0 {
+			t.Error("thread details", r)
+		}
+		if got := field(t, c.get("/db/api/forum/listPosts/?forum=f&order=asc"), "id"); got != ids(1, 2, 3) {
+			t.Error("forum posts", got)
+		}
+		if got := field(t, c.get("/db/api/thread/listPosts/?thread=1&limit=2"), "id"); got != ids(3, 2) {
+			t.Error("thread posts", got)
+		}
+		if got := field(t, c.get("/db/api/forum/listUsers/?forum=f"), "email"); got != "[a@a]" {
+			t.Error("forum users", got)
+		}
+		c.post("/db/api/user/follow/", map[string]interface{}{"follower": "b@b", "followee": "a@a"})
+		r = c.get("/db/api/user/details/?user=a@a")["response"].(map[string]interface{})
+		if fmt.Sprint(r["followers"]) != "[b@b]" {
+			t.Error("user details", r)
+		}
+
+		status := c.get("/db/api/status/")["response"].(map[string]interface{})
+		if status["user"] != 2.0 || status["forum"] != 1.0 || status["thread"] != 1.0 || status["post"] != 3.0 {
+			t.Error("status", status)
+		}
+		expectCode(t, "clear", c.post("/db/api/clear/", nil), 0)
+		if status := c.get("/db/api/status/")["response"].(map[string]interface{}); status["post"] != 0.0 {
+			t.Error("cleared", status)
+		}
+	})
+}
+
+func TestTree(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c client) {
+		seed(c)
+		root := createPost(c, "2014-01-02 00:00:00", nil)
+		// more than ten children make path segments grow
+		kids := []int{}
+		for i := 0; i < 12; i++ {
+			kids = append(kids, createPost(c, "2014-01-02 00:00:00", root))
+		}
+		chain := []int{createPost(c, "2014-01-02 00:00:00", kids[0])}
+		for i := 0; i < 5; i++ {
+			chain = append(chain, createPost(c, "2014-01-02 00:00:00", chain[len(chain)-1]))
+		}
+		late := createPost(c, "2014-01-02 00:00:00", kids[1])
+		root2 := createPost(c, "2014-01-02 00:00:00", nil)
+		want := append(append([]int{root, kids[0]}, chain...), kids[1], late)
+		want = append(append(want, kids[2:]...), root2)
+
+		list := "/db/api/thread/listPosts/?thread=1&sort=tree"
+		if got := field(t, c.get(list+"&order=asc"), "id"); got != ids(want...) {
+			t.Fatalf("tree\n got %v\nwant %v", got, ids(want...))
+		}
+		desc := append([]int{root2}, want[:len(want)-1]...)
+		if got := field(t, c.get(list+"&order=desc"), "id"); got != ids(desc...) {
+			t.Errorf("tree desc\n got %v\nwant %v", got, ids(desc...))
+		}
+		if err := c.store().RebuildPaths(); err != nil {
+			t.Fatal(err)
+		}
+		if got := field(t, c.get(list+"&order=asc"), "id"); got != ids(want...) {
+			t.Errorf("rebuilt tree\n got %v\nwant %v", got, ids(want...))
+		}
+		if s, ok := c.store().(*sqliteStore); ok {
+			if _, err := s.Map.Exec("update post set last_path = '', children = -1"); err != nil {
+				t.Fatal(err)
+			}
+			backfillPaths(s.Map, s)
+			if got := field(t, c.get(list+"&order=asc"), "id"); got != ids(want...) {
+				t.Errorf("backfilled tree\n got %v\nwant %v", got, ids(want...))
+			}
+		}
+	})
+}
+
+// outline writes nested posts as id:depth with children in brackets and
+// left out replies as +count
+func outline(list interface{}) string {
+	out := ""
+	for _, item := range list.([]interface{}) {
+		post := item.(map[string]interface{})
+		out += fmt.Sprintf(" %v:%v", post["id"], post["depth"])
+		if more, ok := post["moreReplies"]; ok {
+			out += fmt.Sprintf("+%v", more)
+		}
+		if children := post["children"].([]interface{}); len(children) > 0 {
+			out += "[" + outline(children) + " ]"
+		}
+	}
+	return out
+}
+
+func TestNested(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c client) {
+		seed(c)
+		root := createPost(c, "2014-01-02 00:00:00", nil)
+		reply := createPost(c, "2014-01-03 00:00:00", root)
+		createPost(c, "2014-01-05 00:00:00", createPost(c, "2014-01-04 00:00:00", reply))
+		createPost(c, "2014-01-06 00:00:00", nil)
+
+		list := "/db/api/thread/listPosts/?thread=1&format=nested&sort="
+		for query, want := range map[string]string{
+			"tree":                           " 1:0[ 2:1[ 3:2[ 4:3 ] ] ] 5:0",
+			"parent_tree&limit=1":            " 1:0[ 2:1[ 3:2[ 4:3 ] ] ]",
+			"tree&maxDepth=1":                " 1:0[ 2:1+2 ] 5:0",
+			"tree&maxDepth=0":                " 1:0+3 5:0",
+			"tree&since=2014-01-04+00:00:00": " 3:2[ 4:3 ] 5:0",
+			"tree&since=2014-01-04+00:00:00&maxDepth=2": " 3:2+1 5:0",
+		} {
+			r := c.get(list + query)
+			if r["code"] != 0.0 {
+				t.Errorf("%s: %v", query, r)
+			} else if got := outline(r["response"]); got != want {
+				t.Errorf("%s\n got %s\nwant %s", query, got, want)
+			}
+		}
+		expectCode(t, "flat sort", c.get(list+"flat"), 3)
+		expectCode(t, "flat format", c.get("/db/api/thread/listPosts/?thread=1&sort=tree&maxDepth=1"), 3)
+	})
+}
+
+func TestParentTree(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c client) {
+		seed(c)
+		createPost(c, "2014-01-01 00:00:00", nil) // 1
+		createPost(c, "2014-01-05 00:00:00", 1)   // 2
+		createPost(c, "2014-01-03 00:00:00", nil) // 3
+		createPost(c, "2014-01-05 00:00:00", 3)   // 4
+		createPost(c, "2014-01-05 00:00:00", 4)   // 5
+		createPost(c, "2014-01-04 00:00:00", nil) // 6
+		createPost(c, "2014-01-05 00:00:00", 1)   // 7
+		list := "/db/api/thread/listPosts/?thread=1&sort=parent_tree"
+		for _, tc := range []struct{ url, want string }{
+			{list + "&limit=2", ids(1, 2, 7, 3, 4, 5, 6)},
+			{list + "&order=desc&limit=2", ids(6, 3, 4, 5, 1, 2, 7)},
+			{list + "&order=desc&limit=1&since=2014-01-02+00:00:00", ids(6, 3, 4, 5)},
+			{list + "&order=desc", ids(6, 3, 4, 5, 1, 2, 7)},
+		} {
+			if got := walk(t, c, tc.url, "id"); got != tc.want {
+				t.Errorf("%s: got %v, want %v", tc.url, got, tc.want)
+			}
+		}
+		// limit counts root posts, every root comes with all its replies
+		if r := c.get(list + "&limit=1")["response"].([]interface{}); len(r) != 3 {
+			t.Error("roots", r)
+		}
+	})
+}
+
+func TestReplies(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c client) {
+		seed(c)
+		createPost(c, "2014-01-02 00:00:00", nil) // 1
+		createPost(c, "2014-01-02 00:00:00", 1)   // 2
+		createPost(c, "2014-01-02 00:00:00", 2)   // 3
+		createPost(c, "2014-01-02 00:00:00", 1)   // 4
+		createPost(c, "2014-01-02 00:00:00", nil) // 5
+		createPost(c, "2014-01-02 00:00:00", 5)   // 6
+		for i := 0; i < 11; i++ {
+			createPost(c, "2014-01-02 00:00:00", 2) // 7 to 17
+		}
+		createPost(c, "2014-01-02 00:00:00", 3) // 18
+		for query, want := range map[string]string{
+			"post=1":                 ids(2, 3, 18, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 4),
+			"post=1&limit=3":         ids(2, 3, 18),
+			"post=1&depth=1":         ids(2, 4),
+			"post=1&depth=2&limit=3": ids(2, 3, 7),
+			"post=2&depth=1&limit=2": ids(3, 7),
+			"post=3":                 ids(18),
+			"post=18":                "[]",
+		} {
+			if got := field(t, c.get("/db/api/post/replies/?"+query), "id"); got != want {
+				t.Errorf("%s: got %v, want %v", query, got, want)
+			}
+		}
+		r := c.get("/db/api/post/replies/?post=1&format=nested&related=user&related=forum")["response"].([]interface{})
+		n := r[0].(map[string]interface{})
+		if len(r) != 2 || len(n["children"].([]interface{})) != 12 || n["depth"] != 1.0 {
+			t.Fatal(r)
+		}
+		if _, ok := n["user"].(map[string]interface{}); !ok {
+			t.Error("related user", n)
+		}
+		if got := outline(c.get("/db/api/post/replies/?post=2&format=nested&depth=1&limit=2")["response"]); got != " 3:2 7:2" {
+			t.Error("nested depth", got)
+		}
+		expectCode(t, "missing", c.get("/db/api/post/replies/?post=100"), 1)
+		expectCode(t, "depth", c.get("/db/api/post/replies/?post=1&depth=0"), 3)
+		expectCode(t, "format", c.get("/db/api/post/replies/?post=1&format=x"), 3)
+	})
+}
+
+func TestCursors(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c client) {
+		seed(c)
+		createPost(c, "2014-01-02 00:00:00", nil) // 1
+		createPost(c, "2014-01-02 00:00:00", 1)   // 2
+		createPost(c, "2014-01-02 00:00:00", 2)   // 3
+		createPost(c, "2014-01-02 00:00:00", nil) // 4
+		createPost(c, "2014-01-02 00:00:00", 4)   // 5
+		createPost(c, "2014-01-02 00:00:00", 1)   // 6
+		createPost(c, "2014-01-02 00:00:00", nil) // 7
+		for _, tc := range []struct{ url, want string }{
+			{"/db/api/thread/listPosts/?thread=1&limit=2", ids(7, 6, 5, 4, 3, 2, 1)},
+			{"/db/api/forum/listPosts/?forum=f&limit=3&order=asc", ids(1, 2, 3, 4, 5, 6, 7)},
+			{"/db/api/user/listPosts/?user=a@a&limit=4", ids(7, 6, 5, 4, 3, 2, 1)},
+			{"/db/api/post/list/?thread=1&limit=3&order=asc", ids(1, 2, 3, 4, 5, 6, 7)},
+			{"/db/api/post/list/?forum=f&limit=2", ids(7, 6, 5, 4, 3, 2, 1)},
+			{"/db/api/forum/listThreads/?forum=f&limit=1", ids(1)},
+			{"/db/api/thread/list/?user=a@a&limit=1", ids(1)},
+			{"/db/api/post/replies/?post=1&limit=1", ids(2, 3, 6)},
+			{"/db/api/post/replies/?post=1&depth=1&limit=1", ids(2, 6)},
+			{"/db/api/post/replies/?post=1&format=nested&limit=2", ids(2, 6)},
+			{"/db/api/thread/listPosts/?thread=1&sort=tree&limit=3", ids(1, 2, 3, 6, 4, 5, 7)},
+			{"/db/api/thread/listPosts/?thread=1&sort=tree&order=desc&limit=2", ids(7, 4, 5, 1, 2, 3, 6)},
+			{"/db/api/thread/listPosts/?thread=1&sort=parent_tree&order=asc&limit=1", ids(1, 2, 3, 6, 4, 5, 7)},
+		} {
+			if got := walk(t, c, tc.url, "id"); got != tc.want {
+				t.Errorf("%s: got %v, want %v", tc.url, got, tc.want)
+			}
+		}
+		for _, u := range []string{"c@c", "d@d", "e@e"} {
+			c.post("/db/api/user/create/", map[string]interface{}{"email": u, "name": "same", "username": u, "about": ""})
+			c.post("/db/api/user/follow/", map[string]interface{}{"follower": u, "followee": "a@a"})
+			c.post("/db/api/post/create/", map[string]interface{}{"date": "2014-01-02 00:00:00", "thread": 1, "message": "p", "user": u, "forum": "f"})
+		}
+		if got := walk(t, c, "/db/api/user/listFollowers/?user=a@a&limit=2&order=asc", "email"); got != "[c@c d@d e@e]" {
+			t.Error("followers", got)
+		}
+		if got := walk(t, c, "/db/api/forum/listUsers/?forum=f&limit=1", "email"); got != "[e@e d@d c@c a@a]" {
+			t.Error("users", got)
+		}
+		expectCode(t, "bad cursor", c.get("/db/api/thread/listPosts/?thread=1&cursor=!!"), 3)
+	})
+}
+
+func TestVotes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c client) {
+		seed(c)
+		createPost(c, "2014-01-02 00:00:00", nil)
+		for _, kind := range []string{"thread", "post"} {
+			vote := func(user string, v int) map[string]interface{} {
+				return c.post("/db/api/"+kind+"/vote/", map[string]interface{}{kind: 1, "vote": v, "user": user})
+			}
+			check := func(step string, likes, dislikes, points float64) {
+				t.Helper()
+				r := c.get("/db/api/" + kind + "/details/?" + kind + "=1")["response"].(map[string]interface{})
+				if r["likes"] != likes || r["dislikes"] != dislikes || r["points"] != points {
+					t.Errorf("%s %s: %v", kind, step, r)
+				}
+			}
+			for i := 0; i < 3; i++ {
+				expectCode(t, "like", vote("a@a", 1), 0)
+			}
+			check("repeated like", 1, 0, 1)
+			vote("b@b", -1)
+			check("dislike", 1, 1, 0)
+			vote("a@a", -1)
+			check("changed vote", 0, 2, -2)
+			vote("a@a", 0)
+			check("retracted vote", 0, 1, -1)
+			expectCode(t, "anonymous", vote("", 1), 0)
+			check("anonymous", 1, 1, 0)
+			expectCode(t, "anonymous retract", vote("", 0), 3)
+			expectCode(t, "bad vote", vote("a@a", 2), 3)
+			expectCode(t, "missing user", vote("z@z", 1), 1)
+			voters := c.get("/db/api/" + kind + "/listVoters/?" + kind + "=1")["response"].([]interface{})
+			if len(voters) != 1 || voters[0].(map[string]interface{})["user"] != "b@b" {
+				t.Error(kind, "voters", voters)
+			}
+		}
+
+		// concurrent votes of a user leave a single vote behind
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(v int) {
+				defer wg.Done()
+				if err := c.store().VotePost(1, "a@a", v); err != nil {
+					t.Error(err)
+				}
+			}(i%2*2 - 1)
+		}
+		wg.Wait()
+		r := c.get("/db/api/post/details/?post=1")["response"].(map[string]interface{})
+		if likes, dislikes := r["likes"].(float64), r["dislikes"].(float64); likes+dislikes != 3 || r["points"] != likes-dislikes {
+			t.Error("concurrent votes", r)
+		}
+	})
+}
+
+func TestClosedThread(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c client) {
+		seed(c)
+		c.post("/db/api/post/create/", map[string]interface{}{"date": "2014-01-02 00:00:00", "thread": 1, "message": "p",
+			"user": "b@b", "forum": "f"})
+		c.post("/db/api/post/remove/", map[string]interface{}{"post": 1, "user": "a@a"})
+		c.post("/db/api/thread/close/", map[string]interface{}{"thread": 1, "user": "a@a"})
+		expectCode(t, "closed", createPostBy(c, "a@a"), 3)
+		c.post("/db/api/forum/updatePolicy/", map[string]interface{}{"forum": "f", "moderatorsPostClosed": true})
+		expectCode(t, "moderator post", createPostBy(c, "a@a"), 0)
+		expectCode(t, "member post", createPostBy(c, "b@b"), 3)
+		// the caller restores, not the author of the post
+		expectCode(t, "author restore", c.post("/db/api/post/restore/", map[string]interface{}{"post": 1, "user": "b@b"}), 3)
+		expectCode(t, "moderator restore", c.post("/db/api/post/restore/", map[string]interface{}{"post": 1, "user": "a@a"}), 0)
+		c.post("/db/api/thread/remove/", map[string]interface{}{"thread": 1, "user": "a@a"})
+		expectCode(t, "deleted", createPostBy(c, "a@a"), 3)
+	})
+}
+
+// createPostBy adds a root post of user to thread 1
+func createPostBy(c client, user string) map[string]interface{} {
+	return c.post("/db/api/post/create/", map[string]interface{}{"date": "2014-01-03 00:00:00", "thread": 1, "message": "p",
+		"user": user, "forum": "f"})
+}
+
+// failingStore breaks the subscriptions of users
+type failingStore struct {
+	Store
+}
+
+func (s failingStore) UserSubscriptions(email string, page Page) ([]Subscription, error) {
+	return nil, errors.New("broken")
+}
+
+func TestUserExport(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c client) {
+		seed(c)
+		c.post("/db/api/post/create/", map[string]interface{}{"date": "2014-01-02 00:00:00", "thread": 1, "message": "p",
+			"user": "b@b", "forum": "f"})
+		c.post("/db/api/thread/vote/", map[string]interface{}{"thread": 1, "vote": 1, "user": "b@b"})
+		c.post("/db/api/post/vote/", map[string]interface{}{"post": 1, "vote": -1, "user": "b@b"})
+		c.post("/db/api/user/follow/", map[string]interface{}{"follower": "a@a", "followee": "b@b"})
+		c.post("/db/api/thread/subscribe/", map[string]interface{}{"user": "b@b", "thread": 1})
+
+		r := c.get("/db/api/user/export/?user=b@b")
+		response := r["response"].(map[string]interface{})
+		if code(r) != 0 || response["profile"].(map[string]interface{})["email"] != "b@b" {
+			t.Fatal(r)
+		}
+		for section, want := range map[string]int{"posts": 1, "threads": 0, "votes": 2, "followers": 1, "following": 0, "subscriptions": 1} {
+			if got := len(response[section].([]interface{})); got != want {
+				t.Errorf("%s: %d items, want %d", section, got, want)
+			}
+		}
+		expectCode(t, "missing", c.get("/db/api/user/export/?user=z@z"), 1)
+
+		// an error after the status was sent still ends a valid document
+		broken := newClient(t, failingStore{c.store()})
+		r = broken.get("/db/api/user/export/?user=b@b")
+		if code(r) != 4 || r["error"] != ErrUnknown.Message || len(r["response"].(map[string]interface{})["posts"].([]interface{})) != 1 {
+			t.Error("broken export", r)
+		}
+
+		expectCode(t, "forum owner", c.post("/db/api/user/remove/", map[string]interface{}{"user": "a@a"}), 3)
+		expectCode(t, "remove", c.post("/db/api/user/remove/", map[string]interface{}{"user": "b@b"}), 0)
+		expectCode(t, "removed", c.get("/db/api/user/export/?user=b@b"), 1)
+		if r := c.get("/db/api/post/details/?post=1")["response"].(map[string]interface{}); r["user"] == "b@b" {
+			t.Error("anonymized post", r)
+		}
+	})
+}
+
+func TestExportPages(t *testing.T) {
+	calls := []string{}
+	err := exportPages(func(page Page) (int, Cursor, error) {
+		after := 0
+		if page.After != nil {
+			after = page.After.ID
+		}
+		calls = append(calls, fmt.Sprint(after))
+		if len(calls) == 3 {
+			return 1, Cursor{ID: after + 1}, nil
+		}
+		return exportBatch, Cursor{ID: after + exportBatch}, nil
+	})
+	if err != nil || fmt.Sprint(calls) != "[0 500 1000]" {
+		t.Error(calls, err)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-gorp/gorp"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Migration is a versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// migrator applies and rolls back migrations of one dialect
+type migrator struct {
+	Map        *gorp.DbMap
+	Migrations []Migration
+}
+
+func dialectName(dial string) string {
+	if dial == "" {
+		return "mysql"
+	}
+	return dial
+}
+
+// loadMigrations reads migrations/<dial>/<version>_<name>.(up|down).sql files
+func loadMigrations(dial string) ([]Migration, error) {
+	dir := path.Join("migrations", dialectName(dial))
+	files, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".sql")
+		parts := strings.SplitN(name, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, errors.New("bad migration file name " + file.Name())
+		}
+		body, err := fs.ReadFile(migrationFiles, path.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version}
+			byVersion[version] = migration
+		}
+		if strings.HasSuffix(parts[1], ".up") {
+			migration.Name = strings.TrimSuffix(parts[1], ".up")
+			migration.Up = string(body)
+		} else if strings.HasSuffix(parts[1], ".down") {
+			migration.Down = string(body)
+		}
+	}
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func newMigrator(dbmap *gorp.DbMap, dial string) (*migrator, error) {
+	migrations, err := loadMigrations(dial)
+	if err != nil {
+		return nil, err
+	}
+	m := &migrator{Map: dbmap, Migrations: migrations}
+	_, err = dbmap.Exec("create table if not exists schema_version (version integer not null primary key, name varchar(150) not null)")
+	return m, err
+}
+
+// statements splits a migration into single statements, MySQL can't run them at once
+func statements(script string) []string {
+	result := []string{}
+	for _, statement := range strings.Split(script, ";\n") {
+		if statement = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(statement), ";")); statement != "" {
+			result = append(result, statement)
+		}
+	}
+	return result
+}
+
+// Applied returns versions of applied migrations in ascending order
+func (m *migrator) Applied() ([]int, error) {
+	var versions []int
+	_, err := m.Map.Select(&versions, "select version from schema_version order by version")
+	return versions, err
+}
+
+func (m *migrator) run(script string, record string, args ...interface{}) error {
+	return inTx(m.Map, func(tx *gorp.Transaction) error {
+		for _, statement := range statements(script) {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(record, args...)
+		return err
+	})
+}
+
+// Up applies at most steps pending migrations, all of them when steps <= 0
+func (m *migrator) Up(steps int) error {
+	if steps <= 0 {
+		steps = len(m.Migrations)
+	}
+	applied, err := m.Applied()
+	if err != nil {
+		return err
+	}
+	done := map[int]bool{}
+	for _, version := range applied {
+		done[version] = true
+	}
+	bindVar := m.Map.Dialect.BindVar
+	record := "insert into schema_version (version, name) values (" + bindVar(0) + ", " + bindVar(1) + ")"
+	for _, migration := range m.Migrations {
+		if done[migration.Version] {
+			continue
+		}
+		if steps == 0 {
+			break
+		}
+		log.Infof("applying migration %04d_%s", migration.Version, migration.Name)
+		if err = m.run(migration.Up, record, migration.Version, migration.Name); err != nil {
+			return fmt.Errorf("migration %04d_%s: %v", migration.Version, migration.Name, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+// Down rolls back the last steps applied migrations
+func (m *migrator) Down(steps int) error {
+	applied, err := m.Applied()
+	if err != nil {
+		return err
+	}
+	byVersion := map[int]Migration{}
+	for _, migration := range m.Migrations {
+		byVersion[migration.Version] = migration
+	}
+	record := "delete from schema_version where version = " + m.Map.Dialect.BindVar(0)
+	for i := len(applied) - 1; i >= 0 && steps > 0; i-- {
+		migration, ok := byVersion[applied[i]]
+		if !ok {
+			return fmt.Errorf("migration %04d is unknown", applied[i])
+		}
+		log.Infof("rolling back migration %04d_%s", migration.Version, migration.Name)
+		if err = m.run(migration.Down, record, migration.Version); err != nil {
+			return fmt.Errorf("migration %04d_%s: %v", migration.Version, migration.Name, err)
+		}
+		steps--
+	}
+	return nil
+}
+
+// Status prints every known migration and whether it is applied
+func (m *migrator) Status() error {
+	applied, err := m.Applied()
+	if err != nil {
+		return err
+	}
+	done := map[int]bool{}
+	for _, version := range applied {
+		done[version] = true
+	}
+	for _, migration := range m.Migrations {
+		state := "pending"
+		if done[migration.Version] {
+			state = "applied"
+		}
+		fmt.Printf("%04d_%s\t%s\n", migration.Version, migration.Name, state)
+	}
+	return nil
+}
+
+// runMigrate handles "migrate [up|down|status] [steps]"
+func runMigrate(config *Config, args []string) error {
+	if config.DIAL == "memory" {
+		return errors.New("memory store has no schema")
+	}
+	dbmap := openDB(config)
+	defer dbmap.Db.Close()
+	m, err := newMigrator(dbmap, config.DIAL)
+	if err != nil {
+		return err
+	}
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	steps := 0
+	if command == "down" {
+		steps = 1
+	}
+	if len(args) > 1 {
+		if steps, err = strconv.Atoi(args[1]); err != nil {
+			return err
+		}
+	}
+	switch command {
+	case "up":
+		return m.Up(steps)
+	case "down":
+		return m.Down(steps)
+	case "status":
+		return m.Status()
+	}
+	return errors.New("usage: migrate [up|down|status] [steps]")
+}
@@ -1,23 +1,4 @@
-DROP DATABASE IF EXISTS `forumDB`;
-CREATE DATABASE `forumDB` DEFAULT CHARACTER SET utf8 DEFAULT COLLATE utf8_general_ci;
-USE `forumDB`;
-
-
-
-
-SET @PREVIOUS_FOREIGN_KEY_CHECKS = @@FOREIGN_KEY_CHECKS;
-SET FOREIGN_KEY_CHECKS = 0;
-
-
-DROP TABLE IF EXISTS `user`;
-DROP TABLE IF EXISTS `thread`;
-DROP TABLE IF EXISTS `subscription`;
-DROP TABLE IF EXISTS `post`;
-DROP TABLE IF EXISTS `forum`;
-DROP TABLE IF EXISTS `follow`;
-
-
-CREATE TABLE `follow` (
+CREATE TABLE IF NOT EXISTS `follow` (
   `follower` varchar(150) NOT NULL,
   `following` varchar(150) NOT NULL,
   PRIMARY KEY (`follower`,`following`),
@@ -25,7 +6,7 @@ CREATE TABLE `follow` (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
 
-CREATE TABLE `forum` (
+CREATE TABLE IF NOT EXISTS `forum` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   `name` varchar(150) NOT NULL,
   `short_name` varchar(150) NOT NULL,
@@ -35,7 +16,7 @@ CREATE TABLE `forum` (
 ) ENGINE=InnoDB AUTO_INCREMENT=289 DEFAULT CHARSET=utf8;
 
 
-CREATE TABLE `post` (
+CREATE TABLE IF NOT EXISTS `post` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   `date` datetime NOT NULL,
   `message` text NOT NULL,
@@ -62,14 +43,14 @@ CREATE TABLE `post` (
 ) ENGINE=InnoDB AUTO_INCREMENT=1000454 DEFAULT CHARSET=utf8;
 
 
-CREATE TABLE `subscription` (
+CREATE TABLE IF NOT EXISTS `subscription` (
   `user` varchar(150) NOT NULL,
   `thread` int(11) NOT NULL,
   PRIMARY KEY (`user`,`thread`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
 
-CREATE TABLE `thread` (
+CREATE TABLE IF NOT EXISTS `thread` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   `title` varchar(150) NOT NULL,
   `date` datetime NOT NULL,
@@ -89,7 +70,7 @@ CREATE TABLE `thread` (
 ) ENGINE=InnoDB AUTO_INCREMENT=10372 DEFAULT CHARSET=utf8;
 
 
-CREATE TABLE `user` (
+CREATE TABLE IF NOT EXISTS `user` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   `email` varchar(150) NOT NULL,
   `username` varchar(150) DEFAULT NULL,
@@ -101,10 +82,3 @@ CREATE TABLE `user` (
   UNIQUE KEY `idx_name` (`name`,`email`) USING BTREE,
   KEY `idx_id_name` (`id`,`name`) USING BTREE
 ) ENGINE=InnoDB AUTO_INCREMENT=100287 DEFAULT CHARSET=utf8;
-
-
-
-
-SET FOREIGN_KEY_CHECKS = @PREVIOUS_FOREIGN_KEY_CHECKS;
-
-
@@ -0,0 +1,5 @@
+ALTER TABLE `post`
+  DROP KEY `idx_thread_first_path_last_path`,
+  DROP `children`,
+  MODIFY `last_path` varchar(150) NOT NULL DEFAULT '',
+  ADD KEY `idx_thread_first_path_last_path` (`thread`,`first_path`,`last_path`) USING BTREE;
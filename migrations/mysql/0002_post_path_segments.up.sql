@@ -0,0 +1,6 @@
+ALTER TABLE `post`
+  MODIFY `last_path` text NOT NULL,
+  ADD `children` int(11) NOT NULL DEFAULT '0',
+  DROP KEY `idx_thread_first_path_last_path`,
+  ADD KEY `idx_thread_first_path_last_path` (`thread`,`first_path`,`last_path`(200)) USING BTREE;
+UPDATE `post` SET `children` = -1;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `thread_vote` (
+  `thread` int(11) NOT NULL,
+  `user` varchar(150) NOT NULL,
+  `vote` tinyint(1) NOT NULL,
+  PRIMARY KEY (`thread`,`user`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+
+CREATE TABLE IF NOT EXISTS `post_vote` (
+  `post` int(11) NOT NULL,
+  `user` varchar(150) NOT NULL,
+  `vote` tinyint(1) NOT NULL,
+  PRIMARY KEY (`post`,`user`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1 @@
+ALTER TABLE `forum` DROP `moderatorsPostClosed`;
@@ -0,0 +1 @@
+ALTER TABLE `forum` ADD `moderatorsPostClosed` tinyint(1) NOT NULL DEFAULT '0';
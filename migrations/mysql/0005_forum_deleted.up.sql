@@ -0,0 +1 @@
+ALTER TABLE `forum` ADD `isDeleted` tinyint(1) NOT NULL DEFAULT '0';
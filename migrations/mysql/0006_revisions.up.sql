@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS `post_revision` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `post` int(11) NOT NULL,
+  `user` varchar(150) NOT NULL,
+  `date` datetime NOT NULL,
+  `message` text NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_post` (`post`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+
+CREATE TABLE IF NOT EXISTS `thread_revision` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `thread` int(11) NOT NULL,
+  `user` varchar(150) NOT NULL,
+  `date` datetime NOT NULL,
+  `message` text NOT NULL,
+  `slug` varchar(150) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_thread` (`thread`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
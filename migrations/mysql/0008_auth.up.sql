@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `credential` (
+  `user` varchar(150) NOT NULL,
+  `password` varchar(150) NOT NULL,
+  PRIMARY KEY (`user`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+
+CREATE TABLE IF NOT EXISTS `token` (
+  `token` varchar(64) NOT NULL,
+  `user` varchar(150) NOT NULL,
+  `expires` datetime NOT NULL,
+  PRIMARY KEY (`token`),
+  KEY `idx_user` (`user`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
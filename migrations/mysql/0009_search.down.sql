@@ -0,0 +1,2 @@
+ALTER TABLE `thread` DROP INDEX `idx_thread_search`;
+ALTER TABLE `post` DROP INDEX `idx_post_search`;
@@ -0,0 +1,2 @@
+ALTER TABLE `post` ADD FULLTEXT KEY `idx_post_search` (`message`);
+ALTER TABLE `thread` ADD FULLTEXT KEY `idx_thread_search` (`title`,`message`);
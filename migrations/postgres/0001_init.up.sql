@@ -0,0 +1,80 @@
+CREATE TABLE IF NOT EXISTS follow (
+  follower varchar(150) NOT NULL,
+  following varchar(150) NOT NULL,
+  PRIMARY KEY (follower, following)
+);
+CREATE INDEX IF NOT EXISTS idx_following_follower ON follow (following, follower);
+
+
+CREATE TABLE IF NOT EXISTS forum (
+  id serial PRIMARY KEY,
+  name varchar(150) NOT NULL,
+  short_name varchar(150) NOT NULL,
+  "user" varchar(150) NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_short_name ON forum (short_name);
+
+
+CREATE TABLE IF NOT EXISTS post (
+  id serial PRIMARY KEY,
+  date timestamp NOT NULL,
+  message text NOT NULL,
+  parent integer DEFAULT NULL,
+  likes integer NOT NULL DEFAULT 0,
+  dislikes integer NOT NULL DEFAULT 0,
+  points integer NOT NULL DEFAULT 0,
+  isApproved boolean NOT NULL DEFAULT false,
+  isDeleted boolean NOT NULL DEFAULT false,
+  isEdited boolean NOT NULL DEFAULT false,
+  isHighlighted boolean NOT NULL DEFAULT false,
+  isSpam boolean NOT NULL DEFAULT false,
+  forum varchar(150) NOT NULL,
+  thread integer NOT NULL,
+  "user" varchar(150) NOT NULL,
+  path integer[] NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS idx_post_forum_date ON post (forum, date);
+CREATE INDEX IF NOT EXISTS idx_post_user_date ON post ("user", date);
+CREATE INDEX IF NOT EXISTS idx_post_thread_date ON post (thread, date);
+CREATE INDEX IF NOT EXISTS idx_post_thread_path ON post (thread, path);
+CREATE INDEX IF NOT EXISTS idx_post_thread_root ON post (thread, (path[1]));
+CREATE INDEX IF NOT EXISTS idx_post_forum_user ON post (forum, "user");
+
+
+CREATE TABLE IF NOT EXISTS subscription (
+  "user" varchar(150) NOT NULL,
+  thread integer NOT NULL,
+  PRIMARY KEY ("user", thread)
+);
+
+
+CREATE TABLE IF NOT EXISTS thread (
+  id serial PRIMARY KEY,
+  title varchar(150) NOT NULL,
+  date timestamp NOT NULL,
+  slug varchar(150) NOT NULL,
+  message text NOT NULL,
+  likes integer NOT NULL DEFAULT 0,
+  dislikes integer NOT NULL DEFAULT 0,
+  points integer NOT NULL DEFAULT 0,
+  posts integer NOT NULL DEFAULT 0,
+  isClosed boolean NOT NULL DEFAULT false,
+  isDeleted boolean NOT NULL DEFAULT false,
+  forum varchar(150) NOT NULL,
+  "user" varchar(150) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_thread_forum_date ON thread (forum, date);
+CREATE INDEX IF NOT EXISTS idx_thread_user_date ON thread ("user", date);
+
+
+CREATE TABLE IF NOT EXISTS "user" (
+  id serial PRIMARY KEY,
+  email varchar(150) NOT NULL,
+  username varchar(150) DEFAULT NULL,
+  name varchar(150) DEFAULT NULL,
+  about text,
+  isAnonymous boolean NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email ON "user" (email);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_name ON "user" (name, email);
+CREATE INDEX IF NOT EXISTS idx_id_name ON "user" (id, name);
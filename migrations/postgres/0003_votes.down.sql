@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS post_vote;
+DROP TABLE IF EXISTS thread_vote;
@@ -0,0 +1 @@
+ALTER TABLE forum ADD COLUMN moderatorsPostClosed boolean NOT NULL DEFAULT false;
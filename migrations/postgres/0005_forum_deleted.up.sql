@@ -0,0 +1 @@
+ALTER TABLE forum ADD COLUMN isDeleted boolean NOT NULL DEFAULT false;
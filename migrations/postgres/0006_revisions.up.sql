@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS post_revision (
+  id serial PRIMARY KEY,
+  post integer NOT NULL,
+  "user" varchar(150) NOT NULL,
+  date timestamp NOT NULL,
+  message text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_revision_post ON post_revision (post);
+
+
+CREATE TABLE IF NOT EXISTS thread_revision (
+  id serial PRIMARY KEY,
+  thread integer NOT NULL,
+  "user" varchar(150) NOT NULL,
+  date timestamp NOT NULL,
+  message text NOT NULL,
+  slug varchar(150) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_revision_thread ON thread_revision (thread);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS forum_role (
+  forum varchar(150) NOT NULL,
+  "user" varchar(150) NOT NULL,
+  role varchar(20) NOT NULL,
+  PRIMARY KEY (forum, "user")
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS token;
+DROP TABLE IF EXISTS credential;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_thread_search;
+DROP INDEX IF EXISTS idx_post_search;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_post_search ON post USING gin (to_tsvector('simple', message));
+CREATE INDEX IF NOT EXISTS idx_thread_search ON thread USING gin (to_tsvector('simple', title || ' ' || message));
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS `user`;
+DROP TABLE IF EXISTS `thread`;
+DROP TABLE IF EXISTS `subscription`;
+DROP TABLE IF EXISTS `post`;
+DROP TABLE IF EXISTS `forum`;
+DROP TABLE IF EXISTS `follow`;
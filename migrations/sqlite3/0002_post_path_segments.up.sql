@@ -0,0 +1,2 @@
+ALTER TABLE `post` ADD COLUMN `children` integer NOT NULL DEFAULT 0;
+UPDATE `post` SET `children` = -1;
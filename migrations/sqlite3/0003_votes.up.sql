@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `thread_vote` (
+  `thread` integer NOT NULL,
+  `user` varchar(150) NOT NULL,
+  `vote` integer NOT NULL,
+  PRIMARY KEY (`thread`,`user`)
+);
+
+
+CREATE TABLE IF NOT EXISTS `post_vote` (
+  `post` integer NOT NULL,
+  `user` varchar(150) NOT NULL,
+  `vote` integer NOT NULL,
+  PRIMARY KEY (`post`,`user`)
+);
@@ -0,0 +1 @@
+ALTER TABLE `forum` ADD COLUMN `moderatorsPostClosed` integer NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE `forum` ADD COLUMN `isDeleted` integer NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `thread_revision`;
+DROP TABLE IF EXISTS `post_revision`;
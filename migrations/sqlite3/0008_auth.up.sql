@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `credential` (
+  `user` varchar(150) NOT NULL PRIMARY KEY,
+  `password` varchar(150) NOT NULL
+);
+
+
+CREATE TABLE IF NOT EXISTS `token` (
+  `token` varchar(64) NOT NULL PRIMARY KEY,
+  `user` varchar(150) NOT NULL,
+  `expires` datetime NOT NULL
+);
+CREATE INDEX IF NOT EXISTS `idx_token_user` ON `token` (`user`);
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/go-gorp/gorp"
+)
+
+// query builds a statement from trusted SQL fragments, values are always bound as parameters
+type query struct {
+	Dialect gorp.Dialect
+	SQL     strings.Builder
+	Args    []interface{}
+	ordered bool
+	err     error
+}
+
+// newQuery starts a statement, ? placeholders of sql are bound to args
+func newQuery(dialect gorp.Dialect, sql string, args ...interface{}) *query {
+	q := &query{Dialect: dialect}
+	return q.add(sql, args...)
+}
+
+// direction whitelists a sort order, empty means ascending
+func direction(order string) (string, error) {
+	switch order {
+	case "", "asc":
+		return "asc", nil
+	case "desc":
+		return "desc", nil
+	}
+	return "", ErrIncorrect
+}
+
+// add appends a fragment replacing its ? placeholders with the dialect bind variables
+func (q *query) add(fragment string, args ...interface{}) *query {
+	parts := strings.Split(fragment, "?")
+	if len(parts)-1 != len(args) {
+		panic("query: placeholders do not match arguments in " + fragment)
+	}
+	for i, part := range parts {
+		q.SQL.WriteString(part)
+		if i < len(args) {
+			q.SQL.WriteString(q.Dialect.BindVar(len(q.Args)))
+			q.Args = append(q.Args, args[i])
+		}
+	}
+	return q
+}
+
+// And adds a condition to the where clause of the statement
+func (q *query) And(condition string, args ...interface{}) *query {
+	return q.add(" and "+condition, args...)
+}
+
+// OrderBy sorts by column, order must be asc or desc
+func (q *query) OrderBy(column, order string) *query {
+	dir, err := direction(order)
+	if err != nil {
+		q.err = err
+		return q
+	}
+	if q.ordered {
+		q.SQL.WriteString(", ")
+	} else {
+		q.SQL.WriteString(" order by ")
+		q.ordered = true
+	}
+	q.SQL.WriteString(column + " " + dir)
+	return q
+}
+
+// Limit caps the number of rows, zero means all of them
+func (q *query) Limit(limit int) *query {
+	if limit < 0 {
+		q.err = ErrIncorrect
+	} else if limit > 0 {
+		q.add(" limit ?", limit)
+	}
+	return q
+}
+
+// Offset skips the first rows of the result
+func (q *query) Offset(offset int) *query {
+	if offset < 0 {
+		q.err = ErrIncorrect
+	} else if offset > 0 {
+		q.add(" offset ?", offset)
+	}
+	return q
+}
+
+// cursorOp compares keys of rows past a cursor to its key in the order dir
+func cursorOp(dir string) string {
+	if dir == "desc" {
+		return "<"
+	}
+	return ">"
+}
+
+// Page sorts by column and then by the id column in the order of page, skips
+// rows up to the cursor of page and applies its limit. An empty id means that
+// column is unique by itself.
+func (q *query) Page(column, id string, page Page) *query {
+	dir, err := direction(page.Order)
+	if err != nil {
+		q.err = err
+		return q
+	}
+	if after := page.After; after != nil {
+		op := cursorOp(dir)
+		if id == "" {
+			q.And(column+" "+op+" ?", after.Key)
+		} else {
+			q.And("("+column+" "+op+" ? or ("+column+" = ? and "+id+" "+op+" ?))", after.Key, after.Key, after.ID)
+		}
+	}
+	q.OrderBy(column, dir)
+	if id != "" {
+		q.OrderBy(id, dir)
+	}
+	return q.Limit(page.Limit)
+}
+
+// Select runs the statement unless building it failed
+func (q *query) Select(exec gorp.SqlExecutor, holder interface{}) error {
+	if q.err != nil {
+		return q.err
+	}
+	_, err := exec.Select(holder, q.SQL.String(), q.Args...)
+	return err
+}
+
+// placeholders returns a list of n ? placeholders for an in (...) condition
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
+
+func intArgs(values []int) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/gin-gonic/gin.v1"
+)
+
+// limiterSweep is how often a limiter drops the buckets that have refilled
+const limiterSweep = time.Minute
+
+// limiter keeps a token bucket per client, a bucket holds up to burst tokens
+// and gains rate tokens a second, every request takes one
+type limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	sweeper *time.Ticker
+	done    chan struct{}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter returns a limiter, nil when rate is not positive so nothing is
+// limited. Full buckets are swept in the background every limiterSweep until
+// the limiter is stopped.
+func newLimiter(limit Limit) *limiter {
+	if limit.RATE <= 0 {
+		return nil
+	}
+	burst := float64(limit.BURST)
+	if burst < 1 {
+		burst = 1
+	}
+	l := &limiter{rate: limit.RATE, burst: burst, buckets: map[string]*bucket{},
+		sweeper: time.NewTicker(limiterSweep), done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case now := <-l.sweeper.C:
+				l.mu.Lock()
+				l.sweep(now)
+				l.mu.Unlock()
+			case <-l.done:
+				return
+			}
+		}
+	}()
+	return l
+}
+
+// Stop ends the background sweeps of the limiter
+func (l *limiter) Stop() {
+	if l == nil {
+		return
+	}
+	l.sweeper.Stop()
+	close(l.done)
+}
+
+// Allow takes a token of client, when there is none it reports how long to wait for one
+func (l *limiter) Allow(client string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		return false, l.wait(b.tokens)
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Wait tells how long client has to wait for a token, zero when it has one.
+// Unlike Allow it does not take the token.
+func (l *limiter) Wait(client string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		return 0
+	}
+	if tokens := l.refill(b, time.Now()); tokens < 1 {
+		return l.wait(tokens)
+	}
+	return 0
+}
+
+// refill returns the tokens b holds at now
+func (l *limiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
+
+// wait returns how long a bucket of tokens takes to gain a whole one
+func (l *limiter) wait(tokens float64) time.Duration {
+	return time.Duration((1 - tokens) / l.rate * float64(time.Second))
+}
+
+// sweep forgets buckets that have refilled, they are the same as new ones
+func (l *limiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateLimit refuses requests of clients over their limit. It limits requests
+// per IP address unless they bring a token in auth mode, those are limited by
+// authenticate once the token is checked: per user when it is valid and per
+// IP address in a bucket of their own when it is not, so guessing tokens is
+// limited without locking out the users behind the address.
+func (db *DB) rateLimit(c *gin.Context) {
+	if !db.Auth || c.Request.Header.Get("Authorization") == "" {
+		db.limit(c, "ip:"+remoteIP(c))
+	}
+}
+
+// limits returns the limiter of a request, GET requests count against the
+// read limit and the rest against the write one
+func (db *DB) limits(c *gin.Context) *limiter {
+	if c.Request.Method == "GET" {
+		return db.Reads
+	}
+	return db.Writes
+}
+
+// limit takes a token of client and aborts the request when there is none, it
+// tells whether the request may go on
+func (db *DB) limit(c *gin.Context, client string) bool {
+	ok, wait := db.limits(c).Allow(client)
+	if !ok {
+		tooMany(c, wait)
+	}
+	return ok
+}
+
+// tooMany aborts a request over its rate limit, the client may retry after wait
+func tooMany(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	fail(c, ErrRateLimited)
+	c.Abort()
+}
+
+// remoteIP is the address the request came from. Forwarding headers are not
+// trusted as any client can set them, behind a proxy all clients share its
+// address.
+func remoteIP(c *gin.Context) string {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	if l := newLimiter(Limit{}); l != nil {
+		t.Error("zero rate limits")
+	}
+	var none *limiter
+	if ok, _ := none.Allow("a"); !ok || none.Wait("a") != 0 {
+		t.Error("nil limiter refused")
+	}
+	none.Stop()
+	l := newLimiter(Limit{RATE: 1, BURST: 2})
+	defer l.Stop()
+	for i, want := range []bool{true, true, false} {
+		if ok, wait := l.Allow("a"); ok != want || !ok && wait <= 0 {
+			t.Error(i, ok, wait)
+		}
+	}
+	if l.Wait("a") <= 0 || l.Wait("b") != 0 {
+		t.Error("wait", l.Wait("a"), l.Wait("b"))
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("buckets are per client")
+	}
+	if l.Wait("b") != 0 || l.buckets["b"].tokens != 1 {
+		t.Error("wait takes no token", l.buckets["b"])
+	}
+	l.buckets["a"].last = time.Now().Add(-time.Hour)
+	l.sweep(time.Now())
+	if _, ok := l.buckets["a"]; ok || len(l.buckets) != 1 {
+		t.Error("sweep", l.buckets)
+	}
+}
+
+// lookupStore counts token lookups
+type lookupStore struct {
+	Store
+	lookups int
+}
+
+func (s *lookupStore) TokenUser(hash, now string) (string, error) {
+	s.lookups++
+	return s.Store.TokenUser(hash, now)
+}
+
+func TestRateLimit(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c client) {
+		store := &lookupStore{Store: c.db.Store}
+		c.db.Store = store
+		c.db.Reads = newLimiter(Limit{RATE: 0.001, BURST: 2})
+		c.db.Writes = newLimiter(Limit{RATE: 0.001, BURST: 2})
+		defer c.db.Reads.Stop()
+		defer c.db.Writes.Stop()
+		status := func(c client, addr, forwarded, token string) (int, string) {
+			req := httptest.NewRequest("GET", "/db/api/status/", nil)
+			req.RemoteAddr = addr
+			if forwarded != "" {
+				req.Header.Set("X-Forwarded-For", forwarded)
+			}
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			c.r.ServeHTTP(w, req)
+			out := map[string]interface{}{}
+			if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+			return code(out), w.Header().Get("Retry-After")
+		}
+		// forwarding headers do not make a new client
+		for i, forwarded := range []string{"", "10.0.0.1", "10.0.0.2"} {
+			got, retry := status(c, "192.0.2.7:1000", forwarded, "")
+			if i < 2 && got != 0 || i == 2 && (got != 7 || retry == "") {
+				t.Error(i, got, retry)
+			}
+			if retry != "" {
+				if seconds, err := strconv.Atoi(retry); err != nil || seconds <= 0 {
+					t.Error("Retry-After", retry)
+				}
+			}
+		}
+		// open mode ignores tokens, they do not escape the address limit
+		if got, _ := status(c, "192.0.2.7:1000", "", "any"); got != 7 {
+			t.Error("open mode token", got)
+		}
+		if got, _ := status(c, "192.0.2.8:1000", "", ""); got != 0 {
+			t.Error("other address", got)
+		}
+
+		c = c.withAuth()
+		// failed authentications count against the address, once it is out
+		// of tokens they are not looked up
+		for i, want := range []int{6, 6, 7, 7} {
+			if got, _ := status(c, "192.0.2.9:1000", "", "guess"); got != want {
+				t.Error("unknown token", i, got)
+			}
+		}
+		if store.lookups != 2 {
+			t.Error("lookups", store.lookups)
+		}
+		// callers with a token are limited per user
+		c.post("/db/api/user/create/", map[string]interface{}{"email": "a@a", "password": "pw"})
+		token := login(t, c, "a@a", "pw")
+		for i, want := range []int{0, 0, 7} {
+			if got, _ := status(c, "192.0.2.7:1000", "", token); got != want {
+				t.Error("user", i, got)
+			}
+		}
+		expectCode(t, "writes", c.post("/db/api/user/create/", map[string]interface{}{"email": "b@b"}), 7)
+	})
+}
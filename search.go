@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// search result types
+const (
+	searchPost   = "post"
+	searchThread = "thread"
+)
+
+// docKey identifies an indexed post or thread
+type docKey struct {
+	Type string
+	ID   int
+}
+
+// searchIndex is an inverted index of post and thread texts for stores that
+// have no full-text search of their own
+type searchIndex struct {
+	mu    sync.RWMutex
+	terms map[string]map[docKey]int
+	docs  map[docKey][]string
+	built bool
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{terms: map[string]map[docKey]int{}, docs: map[docKey][]string{}}
+}
+
+// Add indexes text of a document replacing what was indexed for it before
+func (ix *searchIndex) Add(key docKey, text string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.add(key, text)
+}
+
+func (ix *searchIndex) add(key docKey, text string) {
+	for _, term := range ix.docs[key] {
+		delete(ix.terms[term], key)
+		if len(ix.terms[term]) == 0 {
+			delete(ix.terms, term)
+		}
+	}
+	counts := map[string]int{}
+	for _, term := range tokenize(text) {
+		counts[term]++
+	}
+	terms := make([]string, 0, len(counts))
+	for term, count := range counts {
+		if ix.terms[term] == nil {
+			ix.terms[term] = map[docKey]int{}
+		}
+		ix.terms[term][key] = count
+		terms = append(terms, term)
+	}
+	ix.docs[key] = terms
+}
+
+// Build fills the index once with the documents load passes to its add
+// function, later calls do nothing
+func (ix *searchIndex) Build(load func(add func(key docKey, text string)) error) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.built {
+		return nil
+	}
+	if err := load(ix.add); err != nil {
+		return err
+	}
+	ix.built = true
+	return nil
+}
+
+// Reset empties the index, it counts as built since there is nothing to load
+func (ix *searchIndex) Reset() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.terms = map[string]map[docKey]int{}
+	ix.docs = map[docKey][]string{}
+	ix.built = true
+}
+
+// Search scores documents having any term of text with tf-idf, rarer terms weigh more
+func (ix *searchIndex) Search(text string) map[docKey]float64 {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	scores := map[docKey]float64{}
+	total := float64(len(ix.docs))
+	for _, term := range searchTerms(text) {
+		postings := ix.terms[term]
+		idf := math.Log(1 + total/float64(len(postings)+1))
+		for key, count := range postings {
+			scores[key] += float64(count) * idf
+		}
+	}
+	return scores
+}
+
+// tokenize splits text into lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchTerms returns the distinct words of a search query
+func searchTerms(text string) []string {
+	seen := map[string]bool{}
+	terms := []string{}
+	for _, term := range tokenize(text) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// Match tells whether a result passes the filters of the query
+func (q SearchQuery) Match(result SearchResult) bool {
+	return (q.Forum == "" || result.Forum == q.Forum) &&
+		(q.User == "" || result.User == q.User) &&
+		(q.Since == "" || result.Date >= q.Since) &&
+		(q.Until == "" || result.Date <= q.Until) &&
+		(q.Deleted || !result.IsDeleted) &&
+		(q.Spam || !result.IsSpam)
+}
+
+// rankResults sorts results by relevance and cuts the requested page
+func rankResults(results []SearchResult, query SearchQuery) []SearchResult {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].ID != results[j].ID {
+			return results[i].ID < results[j].ID
+		}
+		return results[i].Type < results[j].Type
+	})
+	if query.Offset >= len(results) {
+		return []SearchResult{}
+	}
+	results = results[query.Offset:]
+	if query.Limit > 0 && query.Limit < len(results) {
+		results = results[:query.Limit]
+	}
+	return results
+}
+
+func postResult(post Post, score float64) SearchResult {
+	return SearchResult{Type: searchPost, ID: post.ID, Score: score, Forum: post.Forum, User: post.User, Date: post.Date,
+		Thread: post.Thread, Message: post.Message, IsDeleted: post.IsDeleted, IsSpam: post.IsSpam}
+}
+
+func threadResult(thread Thread, score float64) SearchResult {
+	return SearchResult{Type: searchThread, ID: thread.ID, Score: score, Forum: thread.Forum, User: thread.User, Date: thread.Date,
+		Thread: thread.ID, Title: thread.Title, Message: thread.Message, IsDeleted: thread.IsDeleted}
+}
+
+// threadText is the indexed text of a thread
+func threadText(thread Thread) string {
+	return thread.Title + " " + thread.Message
+}
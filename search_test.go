@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, c client) {
+		seed(c)
+		c.post("/db/api/thread/create/", map[string]interface{}{"forum": "f", "user": "b@b", "title": "Golang generics", "slug": "g",
+			"date": "2014-02-01 00:00:00", "message": "talk about go"})
+		c.post("/db/api/post/create/", map[string]interface{}{"date": "2014-01-02 00:00:00", "thread": 1, "message": "I love golang, golang rocks", "user": "a@a", "forum": "f"})
+		c.post("/db/api/post/create/", map[string]interface{}{"date": "2014-01-03 00:00:00", "thread": 1, "message": "golang spam", "user": "b@b", "forum": "f", "isSpam": true})
+		c.post("/db/api/post/create/", map[string]interface{}{"date": "2014-01-04 00:00:00", "thread": 1, "message": "nothing here", "user": "b@b", "forum": "f"})
+		results := func(query string) []interface{} {
+			t.Helper()
+			r := c.get("/db/api/search/?" + query)
+			list, ok := r["response"].([]interface{})
+			if !ok {
+				t.Fatal(query, r)
+			}
+			return list
+		}
+		// the post mentions golang twice and ranks above the thread
+		if r := results("query=Golang"); len(r) != 2 || r[0].(map[string]interface{})["type"] != "post" {
+			t.Fatal(r)
+		}
+		for query, want := range map[string]int{
+			"query=golang&spam=true":        3,
+			"query=golang&user=b@b":         1,
+			"query=golang&forum=f":          2,
+			"query=golang&until=2014-01-31": 1,
+			"query=golang&since=2014-01-31": 1,
+			"query=generics":                1,
+			"query=missing":                 0,
+			"query=golang&limit=1":          1,
+			"query=golang&limit=1&offset=5": 0,
+		} {
+			if n := len(results(query)); n != want {
+				t.Errorf("%s: %d results, want %d", query, n, want)
+			}
+		}
+		if r := results("query=golang&limit=1&offset=1"); len(r) != 1 || r[0].(map[string]interface{})["type"] != "thread" {
+			t.Error("second page", r)
+		}
+		expectCode(t, "empty query", c.get("/db/api/search/?query=++"), 2)
+		expectCode(t, "bad flag", c.get("/db/api/search/?query=a&spam=yes"), 3)
+
+		c.post("/db/api/post/update/", map[string]interface{}{"post": 3, "user": "b@b", "message": "now golang"})
+		if n := len(results("query=golang")); n != 3 {
+			t.Error("updated post", n)
+		}
+		c.post("/db/api/post/remove/", map[string]interface{}{"post": 3})
+		if n := len(results("query=golang")); n != 2 {
+			t.Error("removed post", n)
+		}
+		c.post("/db/api/clear/", nil)
+		if n := len(results("query=golang")); n != 0 {
+			t.Error("cleared", n)
+		}
+	})
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Page holds since, order and limit params of list queries, post lists also
+// leave out spam and unapproved posts on demand. A list given a cursor starts
+// right after the item it points to.
+type Page struct {
+	Since          string
+	Order          string
+	Limit          int
+	After          *Cursor
+	HideSpam       bool
+	HideUnapproved bool
+}
+
+// Cursor points to an item of a list by its sort key and id, tree sorts also
+// keep the first_path of the post in Root
+type Cursor struct {
+	Key  string `json:"k"`
+	Root int    `json:"r,omitempty"`
+	ID   int    `json:"i,omitempty"`
+}
+
+// String encodes the cursor as an opaque token
+func (c Cursor) String() string {
+	token, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// parseCursor decodes a token made by Cursor.String
+func parseCursor(token string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrIncorrect
+	}
+	cursor := &Cursor{}
+	if err = json.Unmarshal(data, cursor); err != nil {
+		return nil, ErrIncorrect
+	}
+	return cursor, nil
+}
+
+// SearchQuery holds the text and filters of a full-text search, deleted
+// posts and threads and spam posts are found only when asked for
+type SearchQuery struct {
+	Text    string
+	Forum   string
+	User    string
+	Since   string
+	Until   string
+	Deleted bool
+	Spam    bool
+	Limit   int
+	Offset  int
+}
+
+// Store is a storage backend of the API
+type Store interface {
+	Clear() error
+	Status() (map[string]int64, error)
+	Close() error
+
+	CreateForum(forum *Forum) error
+	Forum(shortName string) (Forum, error)
+	ForumPosts(shortName string, page Page) ([]Post, error)
+	ForumThreads(shortName string, page Page) ([]Thread, error)
+	ForumUsers(shortName string, page Page) ([]User, error)
+	ModerationQueue(shortName string, page Page) ([]Post, error)
+	UpdateForumPolicy(shortName string, moderatorsPostClosed bool) error
+	UpdateForum(shortName, name, user string) error
+	RenameForum(shortName, newShortName string) error
+	RemoveForum(shortName string) error
+	RestoreForum(shortName string) error
+	ForumRole(shortName, user string) (string, error)
+	ForumRoles(shortName string) ([]Role, error)
+	SetForumRole(shortName, user, role string) error
+	RemoveForumRole(shortName, user string) error
+
+	CreateThread(thread *Thread) error
+	Thread(id int) (Thread, error)
+	Threads(ids []int) ([]Thread, error)
+	ThreadPosts(id int, sort string, page Page) ([]Post, error)
+	CloseThread(id int, closed bool) error
+	RemoveThread(id int) error
+	RestoreThread(id int) error
+	UpdateThread(id int, message, slug, user, date string) error
+	ThreadRevisions(id int) ([]Revision, error)
+	VoteThread(id int, user string, vote int) error
+	ThreadVotes(id int) ([]Vote, error)
+	Subscribe(email string, thread int) error
+	Unsubscribe(email string, thread int) error
+	Subscriptions(emails []string) ([]Subscription, error)
+	UserSubscriptions(email string, page Page) ([]Subscription, error)
+
+	CreatePost(post *Post) error
+	Post(id int) (Post, error)
+	PostReplies(id, depth int, page Page) ([]Post, error)
+	RemovePost(id int) error
+	RestorePost(id int) error
+	UpdatePost(id int, message, user, date string) error
+	PostRevisions(id int) ([]Revision, error)
+	ApprovePost(id int, approved bool) error
+	MarkSpam(id int, spam bool) error
+	HighlightPost(id int, highlighted bool) error
+	VotePost(id int, user string, vote int) error
+	PostVotes(id int) ([]Vote, error)
+	RebuildPaths() error
+
+	CreateUser(user *User) error
+	User(email string) (User, error)
+	Users(emails []string) ([]User, error)
+	UserPosts(email string, page Page) ([]Post, error)
+	UserThreads(email string, page Page) ([]Thread, error)
+	UpdateUser(email, about, name string) error
+	RemoveUser(email, alias string) error
+	UserVotes(email, entity string, page Page) ([]Vote, error)
+	SetPassword(email, hash string) error
+	Password(email string) (string, error)
+	CreateToken(hash, email, now, expires string) error
+	TokenUser(hash, now string) (string, error)
+	RemoveToken(hash string) error
+	Follow(follower, followee string) error
+	Unfollow(follower, followee string) error
+	Followers(email string, page Page) ([]string, error)
+	Following(email string, page Page) ([]string, error)
+	Follows(emails []string) ([]Follow, error)
+
+	Search(query SearchQuery) ([]SearchResult, error)
+}
+
+// hiddenPosts returns conditions leaving out the posts page asks to hide
+func hiddenPosts(page Page) string {
+	conditions := ""
+	if page.HideSpam {
+		conditions += " and isSpam = false"
+	}
+	if page.HideUnapproved {
+		conditions += " and isApproved = true"
+	}
+	return conditions
+}
+
+// queuedPosts is the condition of posts waiting for a moderator
+const queuedPosts = " and isDeleted = false and (isApproved = false or isSpam = true)"
+
+// voteDelta returns how likes, dislikes and points change when a vote of a
+// user is replaced with another one, 0 stands for no vote
+func voteDelta(previous, vote int) (likes, dislikes, points int) {
+	switch previous {
+	case 1:
+		likes--
+	case -1:
+		dislikes--
+	}
+	switch vote {
+	case 1:
+		likes++
+	case -1:
+		dislikes++
+	}
+	return likes, dislikes, vote - previous
+}
+
+// POST PATHS
+// last_path is a chain of segments, one per tree level. A segment is the
+// base-36 ordinal of the post among its siblings prefixed with the length of
+// that ordinal, so plain string order of paths is tree order at any depth.
+// MySQL only compares the first max_sort_length bytes when sorting TEXT, the
+// connection raises it to 8MB so the bound is the 64KB of the column, some
+// 30000 levels of two-character segments.
+const pathDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+func encodeSegment(number int) string {
+	digits := strconv.FormatInt(int64(number), len(pathDigits))
+	return string(pathDigits[len(digits)]) + digits
+}
+
+// childPath returns first_path and last_path of the index-th reply to parent
+func childPath(parent Post, index int) (int, string) {
+	return parent.FirstPath, parent.LastPath + encodeSegment(index)
+}
+
+// treePaths recomputes paths and child counters of thread posts sorted by id
+func treePaths(posts []Post) {
+	byID := map[int]*Post{}
+	for i := range posts {
+		post := &posts[i]
+		post.Children = 0
+		if parent, ok := byID[parentID(post)]; ok {
+			parent.Children++
+			post.FirstPath, post.LastPath = childPath(*parent, parent.Children)
+		} else {
+			post.FirstPath, post.LastPath = post.ID, ""
+		}
+		byID[post.ID] = post
+	}
+}
+
+func parentID(post *Post) int {
+	if post.Parent == nil {
+		return 0
+	}
+	return *post.Parent
+}
+
+// pathDepth returns the tree level of a post from its last_path, root posts
+// are at level 0
+func pathDepth(lastPath string) int {
+	depth := 0
+	for i := 0; i < len(lastPath); depth++ {
+		length := strings.IndexByte(pathDigits, lastPath[i])
+		if length < 1 {
+			break
+		}
+		i += 1 + length
+	}
+	return depth
+}
+
+// setDepths fills the tree levels of posts from their paths
+func setDepths(posts []Post) []Post {
+	for i := range posts {
+		posts[i].Depth = pathDepth(posts[i].LastPath)
+	}
+	return posts
+}
+
+// treeCursor returns the condition of tree sorted posts past the cursor of
+// page, roots come in the order of page and replies by path. firstPath is
+// compared to the root of the cursor and lastPath to the path expression
+// built from keyArgs.
+func treeCursor(page Page, firstPath, lastPath, path string, keyArgs ...interface{}) (string, []interface{}) {
+	return "(" + firstPath + " " + cursorOp(page.Order) + " ? or (" + firstPath + " = ? and " + lastPath + " > " + path + "))",
+		append([]interface{}{page.After.Root, page.After.Root}, keyArgs...)
+}
+
+// threadCursor points to a thread in a list sorted by date
+func threadCursor(thread Thread) Cursor {
+	return Cursor{Key: thread.Date, ID: thread.ID}
+}
+
+// userCursor points to a user in a list sorted by name, users without a name sort as empty ones
+func userCursor(user User) Cursor {
+	return Cursor{Key: userName(user), ID: int(user.ID)}
+}
+
+func userName(user User) string {
+	if user.Name == nil {
+		return ""
+	}
+	return *user.Name
+}
+
+// postCursor points to a post in the list sorted by sort
+func postCursor(post Post, sort string) Cursor {
+	switch sort {
+	case "tree":
+		return Cursor{Key: post.LastPath, Root: post.FirstPath, ID: post.ID}
+	case "parent_tree":
+		return Cursor{Root: post.FirstPath}
+	}
+	return Cursor{Key: post.Date, ID: post.ID}
+}
+
+// countRoots counts root posts of posts sorted by path
+func countRoots(posts []Post) int {
+	count := 0
+	for i := range posts {
+		if i == 0 || posts[i].FirstPath != posts[i-1].FirstPath {
+			count++
+		}
+	}
+	return count
+}
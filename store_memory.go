@@ -0,0 +1,1043 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// voteKey identifies the vote of a user on a thread or post
+type voteKey struct {
+	User string
+	ID   int
+}
+
+// roleKey identifies the role of a user in a forum
+type roleKey struct {
+	Forum string
+	User  string
+}
+
+// memoryToken is the owner and expiry of a stored token hash
+type memoryToken struct {
+	User    string
+	Expires string
+}
+
+// memoryStore keeps entities in process memory, it mimics the MySQL store
+type memoryStore struct {
+	mu            sync.RWMutex
+	forums        map[string]*Forum
+	threads       map[int]*Thread
+	posts         map[int]*Post
+	users         map[string]*User
+	follows       map[Follow]bool
+	subscriptions map[Subscription]bool
+	threadVotes   map[voteKey]int
+	postVotes     map[voteKey]int
+	roles         map[roleKey]string
+	passwords     map[string]string
+	tokens        map[string]memoryToken
+	index         *searchIndex
+	postRevs      []Revision
+	threadRevs    []Revision
+	lastForum     int
+	lastThread    int
+	lastPost      int
+	lastUser      int64
+}
+
+func newMemoryStore() *memoryStore {
+	s := &memoryStore{}
+	s.reset()
+	return s
+}
+
+func (s *memoryStore) reset() {
+	s.forums = map[string]*Forum{}
+	s.threads = map[int]*Thread{}
+	s.posts = map[int]*Post{}
+	s.users = map[string]*User{}
+	s.follows = map[Follow]bool{}
+	s.subscriptions = map[Subscription]bool{}
+	s.threadVotes = map[voteKey]int{}
+	s.postVotes = map[voteKey]int{}
+	s.roles = map[roleKey]string{}
+	s.passwords = map[string]string{}
+	s.tokens = map[string]memoryToken{}
+	s.index = newSearchIndex()
+	s.postRevs, s.threadRevs = nil, nil
+	s.lastForum, s.lastThread, s.lastPost, s.lastUser = 0, 0, 0, 0
+}
+
+func limitOf(length int, page Page) int {
+	if page.Limit > 0 && page.Limit < length {
+		return page.Limit
+	}
+	return length
+}
+
+func less(desc bool, a, b string) bool {
+	if desc {
+		return a > b
+	}
+	return a < b
+}
+
+// keyLess orders items by key and then by id in the same direction, as SQL
+// stores sort pages
+func keyLess(desc bool, key string, id int, otherKey string, otherID int) bool {
+	if key != otherKey {
+		return less(desc, key, otherKey)
+	}
+	if desc {
+		return id > otherID
+	}
+	return id < otherID
+}
+
+// pastCursor tells whether an item comes after the cursor of page
+func pastCursor(page Page, key string, id int) bool {
+	return page.After == nil || keyLess(page.Order == "desc", page.After.Key, page.After.ID, key, id)
+}
+
+// castVote replaces the vote of user in votes and returns how counters change,
+// anonymous votes with an empty user are not kept and only move the counters
+func castVote(votes map[voteKey]int, id int, user string, vote int) (likes, dislikes, points int) {
+	if user == "" {
+		return voteDelta(0, vote)
+	}
+	key := voteKey{User: user, ID: id}
+	previous := votes[key]
+	if vote == 0 {
+		delete(votes, key)
+	} else {
+		votes[key] = vote
+	}
+	return voteDelta(previous, vote)
+}
+
+// listVotes returns the votes on the thread or post with id ordered by user
+func listVotes(votes map[voteKey]int, id int) []Vote {
+	list := []Vote{}
+	for key, vote := range votes {
+		if key.ID == id {
+			list = append(list, Vote{User: key.User, Vote: vote})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].User < list[j].User })
+	return list
+}
+
+// visible tells whether page keeps post, see hiddenPosts
+func visible(post *Post, page Page) bool {
+	return !(page.HideSpam && post.IsSpam) && !(page.HideUnapproved && !post.IsApproved)
+}
+
+func (s *memoryStore) selectPosts(match func(post *Post) bool, page Page) []Post {
+	posts := []Post{}
+	for _, post := range s.posts {
+		if match(post) && post.Date >= page.Since && visible(post, page) && pastCursor(page, post.Date, post.ID) {
+			posts = append(posts, *post)
+		}
+	}
+	desc := page.Order == "desc"
+	sort.Slice(posts, func(i, j int) bool {
+		return keyLess(desc, posts[i].Date, posts[i].ID, posts[j].Date, posts[j].ID)
+	})
+	return posts[:limitOf(len(posts), page)]
+}
+
+func (s *memoryStore) selectThreads(match func(thread *Thread) bool, page Page) []Thread {
+	threads := []Thread{}
+	for _, thread := range s.threads {
+		if match(thread) && thread.Date >= page.Since && pastCursor(page, thread.Date, thread.ID) {
+			threads = append(threads, *thread)
+		}
+	}
+	desc := page.Order == "desc"
+	sort.Slice(threads, func(i, j int) bool {
+		return keyLess(desc, threads[i].Date, threads[i].ID, threads[j].Date, threads[j].ID)
+	})
+	return threads[:limitOf(len(threads), page)]
+}
+
+func (s *memoryStore) selectEmails(emails []string, page Page) []string {
+	since, _ := strconv.ParseInt(page.Since, 10, 64)
+	selected := []string{}
+	for _, email := range emails {
+		if user, ok := s.users[email]; ok && user.ID >= since && pastCursor(page, email, 0) {
+			selected = append(selected, email)
+		}
+	}
+	desc := page.Order == "desc"
+	sort.Slice(selected, func(i, j int) bool {
+		return less(desc, selected[i], selected[j])
+	})
+	return selected[:limitOf(len(selected), page)]
+}
+
+// COMMON
+func (s *memoryStore) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+	return nil
+}
+
+func (s *memoryStore) Status() (map[string]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return map[string]int64{"forum": int64(len(s.forums)), "post": int64(len(s.posts)),
+		"user": int64(len(s.users)), "thread": int64(len(s.threads))}, nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// FORUM
+func (s *memoryStore) CreateForum(forum *Forum) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.forums[forum.ShortName]; ok {
+		return ErrExists
+	}
+	s.lastForum++
+	forum.ID = s.lastForum
+	stored := *forum
+	s.forums[forum.ShortName] = &stored
+	return nil
+}
+
+func (s *memoryStore) Forum(shortName string) (Forum, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if forum, ok := s.forums[shortName]; ok {
+		return *forum, nil
+	}
+	return Forum{}, ErrNotFound
+}
+
+func (s *memoryStore) ForumPosts(shortName string, page Page) ([]Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.selectPosts(func(post *Post) bool { return post.Forum == shortName }, page), nil
+}
+
+func (s *memoryStore) ModerationQueue(shortName string, page Page) ([]Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.selectPosts(func(post *Post) bool {
+		return post.Forum == shortName && !post.IsDeleted && (!post.IsApproved || post.IsSpam)
+	}, page), nil
+}
+
+func (s *memoryStore) ForumThreads(shortName string, page Page) ([]Thread, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.selectThreads(func(thread *Thread) bool { return thread.Forum == shortName }, page), nil
+}
+
+func (s *memoryStore) ForumUsers(shortName string, page Page) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	since, _ := strconv.ParseInt(page.Since, 10, 64)
+	seen := map[string]bool{}
+	users := []User{}
+	for _, post := range s.posts {
+		if post.Forum != shortName || seen[post.User] {
+			continue
+		}
+		seen[post.User] = true
+		if user, ok := s.users[post.User]; ok && user.ID >= since && pastCursor(page, userName(*user), int(user.ID)) {
+			users = append(users, *user)
+		}
+	}
+	desc := page.Order == "desc"
+	sort.Slice(users, func(i, j int) bool {
+		return keyLess(desc, userName(users[i]), int(users[i].ID), userName(users[j]), int(users[j].ID))
+	})
+	return users[:limitOf(len(users), page)], nil
+}
+
+func (s *memoryStore) UpdateForumPolicy(shortName string, moderatorsPostClosed bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if forum, ok := s.forums[shortName]; ok {
+		forum.ModeratorsPostClosed = moderatorsPostClosed
+	}
+	return nil
+}
+
+func (s *memoryStore) UpdateForum(shortName, name, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if forum, ok := s.forums[shortName]; ok {
+		forum.Name = name
+		forum.User = user
+	}
+	return nil
+}
+
+func (s *memoryStore) RenameForum(shortName, newShortName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	forum, ok := s.forums[shortName]
+	if !ok || shortName == newShortName {
+		return nil
+	}
+	if _, ok := s.forums[newShortName]; ok {
+		return ErrExists
+	}
+	delete(s.forums, shortName)
+	forum.ShortName = newShortName
+	s.forums[newShortName] = forum
+	for _, thread := range s.threads {
+		if thread.Forum == shortName {
+			thread.Forum = newShortName
+		}
+	}
+	for _, post := range s.posts {
+		if post.Forum == shortName {
+			post.Forum = newShortName
+		}
+	}
+	for key, role := range s.roles {
+		if key.Forum == shortName {
+			delete(s.roles, key)
+			s.roles[roleKey{Forum: newShortName, User: key.User}] = role
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RemoveForum(shortName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if forum, ok := s.forums[shortName]; ok {
+		forum.IsDeleted = true
+	}
+	for _, thread := range s.threads {
+		if thread.Forum == shortName {
+			thread.IsDeleted = true
+			thread.Posts = 0
+		}
+	}
+	for _, post := range s.posts {
+		if post.Forum == shortName {
+			post.IsDeleted = true
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RestoreForum(shortName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if forum, ok := s.forums[shortName]; ok {
+		forum.IsDeleted = false
+	}
+	posts := map[int]int{}
+	for _, post := range s.posts {
+		if post.Forum == shortName {
+			post.IsDeleted = false
+		}
+		posts[post.Thread]++
+	}
+	for _, thread := range s.threads {
+		if thread.Forum == shortName {
+			thread.IsDeleted = false
+			thread.Posts = posts[thread.ID]
+		}
+	}
+	return nil
+}
+
+// ForumRole returns the stored role of user in a forum, empty if there is none
+func (s *memoryStore) ForumRole(shortName, user string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.roles[roleKey{Forum: shortName, User: user}], nil
+}
+
+func (s *memoryStore) ForumRoles(shortName string) ([]Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	roles := []Role{}
+	for key, role := range s.roles {
+		if key.Forum == shortName {
+			roles = append(roles, Role{User: key.User, Role: role})
+		}
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].User < roles[j].User })
+	return roles, nil
+}
+
+func (s *memoryStore) SetForumRole(shortName, user, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roles[roleKey{Forum: shortName, User: user}] = role
+	return nil
+}
+
+func (s *memoryStore) RemoveForumRole(shortName, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.roles, roleKey{Forum: shortName, User: user})
+	return nil
+}
+
+// THREAD
+func (s *memoryStore) CreateThread(thread *Thread) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastThread++
+	thread.ID = s.lastThread
+	stored := *thread
+	s.threads[thread.ID] = &stored
+	s.index.Add(docKey{searchThread, thread.ID}, threadText(stored))
+	return nil
+}
+
+func (s *memoryStore) Thread(id int) (Thread, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if thread, ok := s.threads[id]; ok {
+		return *thread, nil
+	}
+	return Thread{}, ErrNotFound
+}
+
+func (s *memoryStore) Threads(ids []int) ([]Thread, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	threads := []Thread{}
+	for _, id := range ids {
+		if thread, ok := s.threads[id]; ok {
+			threads = append(threads, *thread)
+		}
+	}
+	return threads, nil
+}
+
+func (s *memoryStore) ThreadPosts(id int, sortType string, page Page) ([]Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	inThread := func(post *Post) bool { return post.Thread == id }
+	if sortType != "tree" && sortType != "parent_tree" {
+		return setDepths(s.selectPosts(inThread, page)), nil
+	}
+	desc := page.Order == "desc"
+	visiblePage := Page{Since: page.Since, HideSpam: page.HideSpam, HideUnapproved: page.HideUnapproved}
+	pastRoot := func(root int) bool {
+		return page.After == nil || root != page.After.Root && (root > page.After.Root) != desc
+	}
+	if sortType == "parent_tree" {
+		// the page of root posts is picked before their replies
+		roots := s.selectPosts(func(post *Post) bool {
+			return post.Thread == id && post.Parent == nil && pastRoot(post.FirstPath)
+		}, visiblePage)
+		sort.Slice(roots, func(i, j int) bool { return (roots[i].FirstPath > roots[j].FirstPath) == desc })
+		paged := map[int]bool{}
+		for _, root := range roots[:limitOf(len(roots), page)] {
+			paged[root.FirstPath] = true
+		}
+		inThread = func(post *Post) bool { return post.Thread == id && paged[post.FirstPath] }
+	} else if page.After != nil {
+		after := *page.After
+		inThread = func(post *Post) bool {
+			if post.Thread != id {
+				return false
+			}
+			if post.FirstPath != after.Root {
+				return pastRoot(post.FirstPath)
+			}
+			return post.LastPath > after.Key
+		}
+	}
+	posts := s.selectPosts(inThread, visiblePage)
+	sort.Slice(posts, func(i, j int) bool {
+		if posts[i].FirstPath != posts[j].FirstPath {
+			return (posts[i].FirstPath > posts[j].FirstPath) == desc
+		}
+		return posts[i].LastPath < posts[j].LastPath
+	})
+	if sortType == "parent_tree" {
+		return setDepths(posts), nil
+	}
+	return setDepths(posts[:limitOf(len(posts), page)]), nil
+}
+
+func (s *memoryStore) CloseThread(id int, closed bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if thread, ok := s.threads[id]; ok {
+		thread.IsClosed = closed
+	}
+	return nil
+}
+
+func (s *memoryStore) RemoveThread(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if thread, ok := s.threads[id]; ok {
+		thread.IsDeleted = true
+		thread.Posts = 0
+	}
+	for _, post := range s.posts {
+		if post.Thread == id {
+			post.IsDeleted = true
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RestoreThread(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	posts := 0
+	for _, post := range s.posts {
+		if post.Thread == id {
+			post.IsDeleted = false
+			posts++
+		}
+	}
+	if thread, ok := s.threads[id]; ok {
+		thread.IsDeleted = false
+		thread.Posts = posts
+	}
+	return nil
+}
+
+func (s *memoryStore) UpdateThread(id int, message, slug, user, date string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if thread, ok := s.threads[id]; ok && (thread.Message != message || thread.Slug != slug) {
+		s.threadRevs = append(s.threadRevs, Revision{ID: len(s.threadRevs) + 1, Thread: id, User: user, Date: date, Message: thread.Message, Slug: thread.Slug})
+		thread.Message = message
+		thread.Slug = slug
+		s.index.Add(docKey{searchThread, id}, threadText(*thread))
+	}
+	return nil
+}
+
+func (s *memoryStore) ThreadRevisions(id int) ([]Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return listRevisions(s.threadRevs, func(revision Revision) bool { return revision.Thread == id }), nil
+}
+
+func (s *memoryStore) VoteThread(id int, user string, vote int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if thread, ok := s.threads[id]; ok {
+		likes, dislikes, points := castVote(s.threadVotes, id, user, vote)
+		thread.Likes += likes
+		thread.Dislikes += dislikes
+		thread.Points += points
+	}
+	return nil
+}
+
+func (s *memoryStore) ThreadVotes(id int) ([]Vote, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return listVotes(s.threadVotes, id), nil
+}
+
+func (s *memoryStore) Subscribe(email string, thread int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := Subscription{User: email, Thread: thread}
+	if s.subscriptions[sub] {
+		return ErrExists
+	}
+	s.subscriptions[sub] = true
+	return nil
+}
+
+func (s *memoryStore) Unsubscribe(email string, thread int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscriptions, Subscription{User: email, Thread: thread})
+	return nil
+}
+
+func (s *memoryStore) Subscriptions(emails []string) ([]Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subs := []Subscription{}
+	for sub := range s.subscriptions {
+		for _, email := range emails {
+			if sub.User == email {
+				subs = append(subs, sub)
+			}
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Thread < subs[j].Thread })
+	return subs, nil
+}
+
+// UserSubscriptions returns threads user subscribed to by ascending id, after
+// the id of the page cursor
+func (s *memoryStore) UserSubscriptions(email string, page Page) ([]Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subs := []Subscription{}
+	for sub := range s.subscriptions {
+		if sub.User == email && (page.After == nil || sub.Thread > page.After.ID) {
+			subs = append(subs, sub)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Thread < subs[j].Thread })
+	return subs[:limitOf(len(subs), page)], nil
+}
+
+// POST
+func (s *memoryStore) CreatePost(post *Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var parent *Post
+	if post.Parent != nil {
+		var ok bool
+		if parent, ok = s.posts[*post.Parent]; !ok {
+			return ErrNotFound
+		}
+	}
+	s.lastPost++
+	post.ID = s.lastPost
+	post.Children = 0
+	if parent == nil {
+		post.FirstPath, post.LastPath = post.ID, ""
+	} else {
+		parent.Children++
+		post.FirstPath, post.LastPath = childPath(*parent, parent.Children)
+	}
+	stored := *post
+	s.posts[post.ID] = &stored
+	s.index.Add(docKey{searchPost, post.ID}, post.Message)
+	if thread, ok := s.threads[post.Thread]; ok {
+		thread.Posts++
+	}
+	return nil
+}
+
+func (s *memoryStore) Post(id int) (Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if post, ok := s.posts[id]; ok {
+		return *post, nil
+	}
+	return Post{}, ErrNotFound
+}
+
+func (s *memoryStore) PostReplies(id, depth int, page Page) ([]Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	parent, ok := s.posts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	maxDepth := pathDepth(parent.LastPath) + depth
+	posts := s.selectPosts(func(post *Post) bool {
+		return post.FirstPath == parent.FirstPath && len(post.LastPath) > len(parent.LastPath) &&
+			strings.HasPrefix(post.LastPath, parent.LastPath) && (depth == 0 || pathDepth(post.LastPath) <= maxDepth) &&
+			(page.After == nil || post.LastPath > page.After.Key)
+	}, Page{Since: page.Since, HideSpam: page.HideSpam, HideUnapproved: page.HideUnapproved})
+	sort.Slice(posts, func(i, j int) bool { return posts[i].LastPath < posts[j].LastPath })
+	return setDepths(posts[:limitOf(len(posts), page)]), nil
+}
+
+func (s *memoryStore) RemovePost(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if post, ok := s.posts[id]; ok && post.IsDeleted != true {
+		post.IsDeleted = true
+		if thread, ok := s.threads[post.Thread]; ok {
+			thread.Posts--
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RestorePost(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if post, ok := s.posts[id]; ok && post.IsDeleted != false {
+		post.IsDeleted = false
+		if thread, ok := s.threads[post.Thread]; ok {
+			thread.Posts++
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) UpdatePost(id int, message, user, date string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if post, ok := s.posts[id]; ok && post.Message != message {
+		s.postRevs = append(s.postRevs, Revision{ID: len(s.postRevs) + 1, Post: id, User: user, Date: date, Message: post.Message})
+		post.Message = message
+		post.IsEdited = true
+		s.index.Add(docKey{searchPost, id}, message)
+	}
+	return nil
+}
+
+func (s *memoryStore) PostRevisions(id int) ([]Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return listRevisions(s.postRevs, func(revision Revision) bool { return revision.Post == id }), nil
+}
+
+func listRevisions(all []Revision, match func(Revision) bool) []Revision {
+	revisions := []Revision{}
+	for _, revision := range all {
+		if match(revision) {
+			revisions = append(revisions, revision)
+		}
+	}
+	return revisions
+}
+
+func (s *memoryStore) ApprovePost(id int, approved bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if post, ok := s.posts[id]; ok {
+		post.IsApproved = approved
+	}
+	return nil
+}
+
+func (s *memoryStore) MarkSpam(id int, spam bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if post, ok := s.posts[id]; ok {
+		post.IsSpam = spam
+	}
+	return nil
+}
+
+func (s *memoryStore) HighlightPost(id int, highlighted bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if post, ok := s.posts[id]; ok {
+		post.IsHighlighted = highlighted
+	}
+	return nil
+}
+
+func (s *memoryStore) VotePost(id int, user string, vote int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if post, ok := s.posts[id]; ok {
+		likes, dislikes, points := castVote(s.postVotes, id, user, vote)
+		post.Likes += likes
+		post.Dislikes += dislikes
+		post.Points += points
+	}
+	return nil
+}
+
+func (s *memoryStore) PostVotes(id int) ([]Vote, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return listVotes(s.postVotes, id), nil
+}
+
+func (s *memoryStore) RebuildPaths() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byThread := map[int][]Post{}
+	for _, post := range s.posts {
+		byThread[post.Thread] = append(byThread[post.Thread], *post)
+	}
+	for _, posts := range byThread {
+		sort.Slice(posts, func(i, j int) bool { return posts[i].ID < posts[j].ID })
+		treePaths(posts)
+		for _, post := range posts {
+			stored := post
+			s.posts[post.ID] = &stored
+		}
+	}
+	return nil
+}
+
+// USER
+func (s *memoryStore) CreateUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[user.Email]; ok {
+		return ErrExists
+	}
+	s.lastUser++
+	user.ID = s.lastUser
+	stored := *user
+	s.users[user.Email] = &stored
+	return nil
+}
+
+func (s *memoryStore) User(email string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if user, ok := s.users[email]; ok {
+		return *user, nil
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *memoryStore) Users(emails []string) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := []User{}
+	for _, email := range emails {
+		if user, ok := s.users[email]; ok {
+			users = append(users, *user)
+		}
+	}
+	return users, nil
+}
+
+func (s *memoryStore) UserPosts(email string, page Page) ([]Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.selectPosts(func(post *Post) bool { return post.User == email }, page), nil
+}
+
+func (s *memoryStore) UserThreads(email string, page Page) ([]Thread, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.selectThreads(func(thread *Thread) bool { return thread.User == email }, page), nil
+}
+
+func (s *memoryStore) UpdateUser(email, about, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[email]; ok {
+		user.About = &about
+		user.Name = &name
+	}
+	return nil
+}
+
+func (s *memoryStore) RemoveUser(email, alias string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[email]
+	if !ok {
+		return nil
+	}
+	for _, forum := range s.forums {
+		if forum.User == email {
+			return ErrOwnsForum
+		}
+	}
+	for follow := range s.follows {
+		if follow.Follower == email || follow.Following == email {
+			delete(s.follows, follow)
+		}
+	}
+	for sub := range s.subscriptions {
+		if sub.User == email {
+			delete(s.subscriptions, sub)
+		}
+	}
+	for key := range s.roles {
+		if key.User == email {
+			delete(s.roles, key)
+		}
+	}
+	delete(s.passwords, email)
+	for token, owner := range s.tokens {
+		if owner.User == email {
+			delete(s.tokens, token)
+		}
+	}
+	for _, post := range s.posts {
+		if post.User == email {
+			post.User = alias
+		}
+	}
+	for _, thread := range s.threads {
+		if thread.User == email {
+			thread.User = alias
+		}
+	}
+	for _, votes := range []map[voteKey]int{s.threadVotes, s.postVotes} {
+		for key, vote := range votes {
+			if key.User == email {
+				delete(votes, key)
+				votes[voteKey{User: alias, ID: key.ID}] = vote
+			}
+		}
+	}
+	for _, revisions := range [][]Revision{s.postRevs, s.threadRevs} {
+		for i := range revisions {
+			if revisions[i].User == email {
+				revisions[i].User = alias
+			}
+		}
+	}
+	delete(s.users, email)
+	user.Email = alias
+	user.Name, user.Username, user.About = nil, nil, nil
+	user.IsAnonymous = true
+	s.users[alias] = user
+	return nil
+}
+
+// UserVotes returns votes of user on threads or posts as entity tells, by
+// ascending id of the thread or post and after the id of the page cursor
+func (s *memoryStore) UserVotes(email, entity string, page Page) ([]Vote, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	votes := s.threadVotes
+	if entity == "post" {
+		votes = s.postVotes
+	}
+	list := []Vote{}
+	for key, vote := range votes {
+		if key.User == email && (page.After == nil || key.ID > page.After.ID) {
+			if entity == "post" {
+				list = append(list, Vote{Post: key.ID, Vote: vote})
+			} else {
+				list = append(list, Vote{Thread: key.ID, Vote: vote})
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Thread+list[i].Post < list[j].Thread+list[j].Post })
+	return list[:limitOf(len(list), page)], nil
+}
+
+func (s *memoryStore) SetPassword(email, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passwords[email] = hash
+	return nil
+}
+
+// Password returns the password hash of a user, empty if none is set
+func (s *memoryStore) Password(email string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.passwords[email], nil
+}
+
+// CreateToken keeps the hash of a new token of a user until expires and drops
+// the tokens of the user expired by now
+func (s *memoryStore) CreateToken(hash, email, now, expires string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, token := range s.tokens {
+		if token.User == email && token.Expires <= now {
+			delete(s.tokens, key)
+		}
+	}
+	s.tokens[hash] = memoryToken{User: email, Expires: expires}
+	return nil
+}
+
+// TokenUser returns the email of the owner of a token hash, ErrNotFound for
+// unknown tokens and those expired by now
+func (s *memoryStore) TokenUser(hash, now string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if token, ok := s.tokens[hash]; ok && token.Expires > now {
+		return token.User, nil
+	}
+	return "", ErrNotFound
+}
+
+func (s *memoryStore) RemoveToken(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, hash)
+	return nil
+}
+
+// SEARCH
+func (s *memoryStore) Search(query SearchQuery) ([]SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := []SearchResult{}
+	for key, score := range s.index.Search(query.Text) {
+		var result SearchResult
+		if post, ok := s.posts[key.ID]; ok && key.Type == searchPost {
+			result = postResult(*post, score)
+		} else if thread, ok := s.threads[key.ID]; ok && key.Type == searchThread {
+			result = threadResult(*thread, score)
+		} else {
+			continue
+		}
+		if query.Match(result) {
+			results = append(results, result)
+		}
+	}
+	return rankResults(results, query), nil
+}
+
+func (s *memoryStore) Follow(follower, followee string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	follow := Follow{Follower: follower, Following: followee}
+	if s.follows[follow] {
+		return ErrExists
+	}
+	s.follows[follow] = true
+	return nil
+}
+
+func (s *memoryStore) Unfollow(follower, followee string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.follows, Follow{Follower: follower, Following: followee})
+	return nil
+}
+
+func (s *memoryStore) Followers(email string, page Page) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	followers := []string{}
+	for follow := range s.follows {
+		if follow.Following == email {
+			followers = append(followers, follow.Follower)
+		}
+	}
+	return s.selectEmails(followers, page), nil
+}
+
+func (s *memoryStore) Following(email string, page Page) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	following := []string{}
+	for follow := range s.follows {
+		if follow.Follower == email {
+			following = append(following, follow.Following)
+		}
+	}
+	return s.selectEmails(following, page), nil
+}
+
+func (s *memoryStore) Follows(emails []string) ([]Follow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	wanted := map[string]bool{}
+	for _, email := range emails {
+		wanted[email] = true
+	}
+	follows := []Follow{}
+	for follow := range s.follows {
+		if wanted[follow.Follower] || wanted[follow.Following] {
+			follows = append(follows, follow)
+		}
+	}
+	sort.Slice(follows, func(i, j int) bool {
+		if follows[i].Follower == follows[j].Follower {
+			return follows[i].Following < follows[j].Following
+		}
+		return follows[i].Follower < follows[j].Follower
+	})
+	return follows, nil
+}
@@ -0,0 +1,825 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+
+	"github.com/go-gorp/gorp"
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlStore keeps entities in MySQL through gorp
+type mysqlStore struct {
+	Map       *gorp.DbMap
+	Duplicate func(err error) bool
+	// ForUpdate ends selects that lock the rows they read
+	ForUpdate string
+}
+
+func newMySQLStore(dbmap *gorp.DbMap) *mysqlStore {
+	return &mysqlStore{Map: dbmap, Duplicate: isMySQLDuplicate, ForUpdate: " for update"}
+}
+
+func isMySQLDuplicate(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
+}
+
+// exists maps unique key violations to ErrExists
+func (s *mysqlStore) exists(err error) error {
+	if err != nil && s.Duplicate(err) {
+		return ErrExists
+	}
+	return err
+}
+
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// inTx runs fn in a transaction and rolls it back when fn fails
+func inTx(dbmap *gorp.DbMap, fn func(tx *gorp.Transaction) error) error {
+	tx, err := dbmap.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// COMMON
+func (s *mysqlStore) Clear() error {
+	tables := []string{"forum", "post", "user", "thread", "follow", "subscription", "thread_vote", "post_vote", "post_revision", "thread_revision", "forum_role", "credential", "token"}
+	for _, table := range tables {
+		if _, err := s.Map.Exec(`truncate table ` + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *mysqlStore) Status() (map[string]int64, error) {
+	tables := []string{"forum", "post", "user", "thread"}
+	status := map[string]int64{}
+	for _, table := range tables {
+		count, err := s.Map.SelectInt(`select count(*) from ` + table)
+		if err != nil {
+			return nil, err
+		}
+		status[table] = count
+	}
+	return status, nil
+}
+
+func (s *mysqlStore) Close() error {
+	return s.Map.Db.Close()
+}
+
+// FORUM
+func (s *mysqlStore) CreateForum(forum *Forum) error {
+	result, err := s.Map.Exec("insert into forum (name, short_name, user, moderatorsPostClosed) values(?, ?, ?, ?)",
+		forum.Name, forum.ShortName, forum.User, forum.ModeratorsPostClosed)
+	if err != nil {
+		return s.exists(err)
+	}
+	id, _ := result.LastInsertId()
+	forum.ID = int(id)
+	return nil
+}
+
+func (s *mysqlStore) Forum(shortName string) (Forum, error) {
+	forum := Forum{}
+	err := s.Map.SelectOne(&forum, "select * from forum where short_name = ?", shortName)
+	return forum, notFound(err)
+}
+
+func (s *mysqlStore) ForumPosts(shortName string, page Page) ([]Post, error) {
+	q := newQuery(s.Map.Dialect, "select * from post where forum = ?"+hiddenPosts(page), shortName)
+	if page.Since != "" {
+		q.And("date >= ?", page.Since)
+	}
+	posts := []Post{}
+	err := q.Page("date", "id", page).Select(s.Map, &posts)
+	return posts, err
+}
+
+func (s *mysqlStore) ModerationQueue(shortName string, page Page) ([]Post, error) {
+	q := newQuery(s.Map.Dialect, "select * from post where forum = ?"+queuedPosts, shortName)
+	if page.Since != "" {
+		q.And("date >= ?", page.Since)
+	}
+	posts := []Post{}
+	err := q.Page("date", "id", page).Select(s.Map, &posts)
+	return posts, err
+}
+
+func (s *mysqlStore) ForumThreads(shortName string, page Page) ([]Thread, error) {
+	q := newQuery(s.Map.Dialect, "select * from thread where forum = ?", shortName)
+	if page.Since != "" {
+		q.And("date >= ?", page.Since)
+	}
+	threads := []Thread{}
+	err := q.Page("date", "id", page).Select(s.Map, &threads)
+	return threads, err
+}
+
+func (s *mysqlStore) ForumUsers(shortName string, page Page) ([]User, error) {
+	q := newQuery(s.Map.Dialect, "select * from user where email IN (select distinct user from post where forum = ?)", shortName)
+	if page.Since != "" {
+		q.And("`user`.`id` >= ?", page.Since)
+	}
+	users := []User{}
+	err := q.Page("coalesce(`user`.`name`, '')", "`user`.`id`", page).Select(s.Map, &users)
+	return users, err
+}
+
+func (s *mysqlStore) UpdateForumPolicy(shortName string, moderatorsPostClosed bool) error {
+	_, err := s.Map.Exec("update forum set moderatorsPostClosed = ? where short_name = ?", moderatorsPostClosed, shortName)
+	return err
+}
+
+func (s *mysqlStore) UpdateForum(shortName, name, user string) error {
+	_, err := s.Map.Exec("update forum set name = ?, user = ? where short_name = ?", name, user, shortName)
+	return err
+}
+
+// RenameForum changes short_name of a forum and of its threads and posts
+func (s *mysqlStore) RenameForum(shortName, newShortName string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if _, err := tx.Exec("update forum set short_name = ? where short_name = ?", newShortName, shortName); err != nil {
+			return s.exists(err)
+		}
+		if _, err := tx.Exec("update thread set forum = ? where forum = ?", newShortName, shortName); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("update forum_role set forum = ? where forum = ?", newShortName, shortName); err != nil {
+			return err
+		}
+		_, err := tx.Exec("update post set forum = ? where forum = ?", newShortName, shortName)
+		return err
+	})
+}
+
+func (s *mysqlStore) RemoveForum(shortName string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if _, err := tx.Exec("update forum set isDeleted = true where short_name = ?", shortName); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("update thread set isDeleted = true, posts = 0 where forum = ?", shortName); err != nil {
+			return err
+		}
+		_, err := tx.Exec("update post set isDeleted = true where forum = ?", shortName)
+		return err
+	})
+}
+
+func (s *mysqlStore) RestoreForum(shortName string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if _, err := tx.Exec("update forum set isDeleted = false where short_name = ?", shortName); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("update post set isDeleted = false where forum = ?", shortName); err != nil {
+			return err
+		}
+		_, err := tx.Exec("update thread set isDeleted = false, posts = (select count(id) from post where post.thread = thread.id) where forum = ?", shortName)
+		return err
+	})
+}
+
+// ForumRole returns the stored role of user in a forum, empty if there is none
+func (s *mysqlStore) ForumRole(shortName, user string) (string, error) {
+	return s.Map.SelectStr("select role from forum_role where forum = ? and user = ?", shortName, user)
+}
+
+func (s *mysqlStore) ForumRoles(shortName string) ([]Role, error) {
+	roles := []Role{}
+	_, err := s.Map.Select(&roles, "select user, role from forum_role where forum = ? order by user", shortName)
+	return roles, err
+}
+
+func (s *mysqlStore) SetForumRole(shortName, user, role string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if _, err := tx.Exec("delete from forum_role where forum = ? and user = ?", shortName, user); err != nil {
+			return err
+		}
+		_, err := tx.Exec("insert into forum_role (forum, user, role) values (?, ?, ?)", shortName, user, role)
+		return err
+	})
+}
+
+func (s *mysqlStore) RemoveForumRole(shortName, user string) error {
+	_, err := s.Map.Exec("delete from forum_role where forum = ? and user = ?", shortName, user)
+	return err
+}
+
+// THREAD
+func (s *mysqlStore) CreateThread(thread *Thread) error {
+	result, err := s.Map.Exec("insert into thread (forum, user, title, isClosed, slug, date, message, IsDeleted) values (?, ?, ?, ?, ?, ?, ?, ?)",
+		thread.Forum, thread.User, thread.Title, thread.IsClosed, thread.Slug, thread.Date, thread.Message, thread.IsDeleted)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	thread.ID = int(id)
+	return nil
+}
+
+func (s *mysqlStore) Thread(id int) (Thread, error) {
+	thread := Thread{}
+	err := s.Map.SelectOne(&thread, "select * from thread where id = ?", id)
+	return thread, notFound(err)
+}
+
+func (s *mysqlStore) Threads(ids []int) ([]Thread, error) {
+	threads := []Thread{}
+	if len(ids) == 0 {
+		return threads, nil
+	}
+	err := newQuery(s.Map.Dialect, "select * from thread where id in ("+placeholders(len(ids))+")", intArgs(ids)...).Select(s.Map, &threads)
+	return threads, err
+}
+
+func (s *mysqlStore) ThreadPosts(id int, sort string, page Page) ([]Post, error) {
+	q := newQuery(s.Map.Dialect, "select * from post where thread = ?"+hiddenPosts(page), id)
+	if page.Since != "" {
+		q.And("date >= ?", page.Since)
+	}
+	posts := []Post{}
+	switch sort {
+	case "tree":
+		if page.After != nil {
+			condition, args := treeCursor(page, "first_path", "last_path", "?", page.After.Key)
+			q.And(condition, args...)
+		}
+		q.OrderBy("first_path", page.Order).OrderBy("last_path", "asc").Limit(page.Limit)
+	case "parent_tree":
+		// the page of root posts is picked first and joined to their replies
+		dir, err := direction(page.Order)
+		if err != nil {
+			return nil, err
+		}
+		q = newQuery(s.Map.Dialect, "select post.* from post join (select first_path from post where thread = ? and parent is null"+hiddenPosts(page), id)
+		if page.Since != "" {
+			q.And("date >= ?", page.Since)
+		}
+		if page.After != nil {
+			q.And("first_path "+cursorOp(dir)+" ?", page.After.Root)
+		}
+		q.add(" order by first_path " + dir).Limit(page.Limit)
+		q.add(") as root on post.first_path = root.first_path where thread = ?"+hiddenPosts(page), id)
+		if page.Since != "" {
+			q.And("date >= ?", page.Since)
+		}
+		q.OrderBy("post.first_path", dir).OrderBy("last_path", "asc")
+	default:
+		q.Page("date", "id", page)
+	}
+	err := q.Select(s.Map, &posts)
+	return setDepths(posts), err
+}
+
+func (s *mysqlStore) CloseThread(id int, closed bool) error {
+	_, err := s.Map.Exec("update thread set isClosed = ? where id = ?", closed, id)
+	return err
+}
+
+func (s *mysqlStore) RemoveThread(id int) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if _, err := tx.Exec("update thread set isDeleted = true, posts = 0 where id = ?", id); err != nil {
+			return err
+		}
+		_, err := tx.Exec("update post set isDeleted = true where thread = ?", id)
+		return err
+	})
+}
+
+func (s *mysqlStore) RestoreThread(id int) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		posts, err := tx.SelectInt("select count(id) from post where thread = ?", id)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec("update thread set isDeleted = false, posts = ? where id = ?", posts, id); err != nil {
+			return err
+		}
+		_, err = tx.Exec("update post set isDeleted = false where thread = ?", id)
+		return err
+	})
+}
+
+// UpdateThread keeps the current message and slug as a revision by user
+// unless nothing changes
+func (s *mysqlStore) UpdateThread(id int, message, slug, user, date string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		result, err := tx.Exec("insert into thread_revision (thread, user, date, message, slug) select id, ?, ?, message, slug from thread where id = ? and (message <> ? or slug <> ?)",
+			user, date, id, message, slug)
+		if err != nil {
+			return err
+		}
+		if changed, err := result.RowsAffected(); err != nil || changed == 0 {
+			return err
+		}
+		_, err = tx.Exec("update thread set message = ?, slug = ? where id = ?", message, slug, id)
+		return err
+	})
+}
+
+func (s *mysqlStore) ThreadRevisions(id int) ([]Revision, error) {
+	revisions := []Revision{}
+	_, err := s.Map.Select(&revisions, "select id, thread, user, date, message, slug from thread_revision where thread = ? order by id", id)
+	return revisions, err
+}
+
+func (s *mysqlStore) VoteThread(id int, user string, vote int) error {
+	return s.castVote("thread", id, user, vote)
+}
+
+func (s *mysqlStore) ThreadVotes(id int) ([]Vote, error) {
+	votes := []Vote{}
+	_, err := s.Map.Select(&votes, "select user, vote from thread_vote where thread = ? order by user", id)
+	return votes, err
+}
+
+func (s *mysqlStore) Subscribe(email string, thread int) error {
+	_, err := s.Map.Exec("insert into subscription (user, thread) values (?, ?)", email, thread)
+	return s.exists(err)
+}
+
+func (s *mysqlStore) Unsubscribe(email string, thread int) error {
+	_, err := s.Map.Exec("delete from subscription where user = ? and thread = ?", email, thread)
+	return err
+}
+
+func (s *mysqlStore) Subscriptions(emails []string) ([]Subscription, error) {
+	subs := []Subscription{}
+	if len(emails) == 0 {
+		return subs, nil
+	}
+	err := newQuery(s.Map.Dialect, "select user, thread from subscription where user in ("+placeholders(len(emails))+") order by thread", stringArgs(emails)...).Select(s.Map, &subs)
+	return subs, err
+}
+
+// UserSubscriptions returns threads user subscribed to by ascending id, after
+// the id of the page cursor
+func (s *mysqlStore) UserSubscriptions(email string, page Page) ([]Subscription, error) {
+	q := newQuery(s.Map.Dialect, "select user, thread from subscription where user = ?", email)
+	if page.After != nil {
+		q.And("thread > ?", page.After.ID)
+	}
+	subs := []Subscription{}
+	err := q.OrderBy("thread", "asc").Limit(page.Limit).Select(s.Map, &subs)
+	return subs, err
+}
+
+// POST
+func (s *mysqlStore) CreatePost(post *Post) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if post.Parent != nil {
+			if _, err := tx.Exec("update post set children = children + 1 where id = ?", post.Parent); err != nil {
+				return err
+			}
+			parent := Post{}
+			if err := tx.SelectOne(&parent, "select first_path, last_path, children from post where id = ?", post.Parent); err != nil {
+				return notFound(err)
+			}
+			post.FirstPath, post.LastPath = childPath(parent, parent.Children)
+		}
+		result, err := tx.Exec("insert into post (date, forum, isApproved, isDeleted, isEdited, isHighlighted, isSpam, message, parent, thread, user, first_path, last_path) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			post.Date, post.Forum, post.IsApproved, post.IsDeleted, post.IsEdited, post.IsHighlighted,
+			post.IsSpam, post.Message, post.Parent, post.Thread, post.User, post.FirstPath, post.LastPath)
+		if err != nil {
+			return err
+		}
+		id, _ := result.LastInsertId()
+		post.ID = int(id)
+
+		if post.Parent == nil {
+			post.FirstPath = post.ID
+			if _, err = tx.Exec("update post set first_path = ? where id = ?", post.FirstPath, post.ID); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec("update thread set posts = posts + 1 where id = ?", post.Thread)
+		return err
+	})
+}
+
+func (s *mysqlStore) Post(id int) (Post, error) {
+	post := Post{}
+	err := s.Map.SelectOne(&post, "select * from post where id = ?", id)
+	return post, notFound(err)
+}
+
+// PostReplies finds replies by last_path prefix. Segments differ in length so
+// paths do not tell the depth in SQL, a depth bound walks down the levels of
+// replies from the post instead.
+func (s *mysqlStore) PostReplies(id, depth int, page Page) ([]Post, error) {
+	parent, err := s.Post(id)
+	if err != nil {
+		return nil, err
+	}
+	q := newQuery(s.Map.Dialect, "select * from post where thread = ? and first_path = ? and last_path like ?"+hiddenPosts(page),
+		parent.Thread, parent.FirstPath, parent.LastPath+"_%")
+	if depth > 0 {
+		q.And(`id in (with recursive replies (id, level) as (
+			select id, 1 from post where parent = ?
+			union all
+			select post.id, replies.level + 1 from post join replies on post.parent = replies.id where replies.level < ?
+		) select id from replies)`, id, depth)
+	}
+	if page.Since != "" {
+		q.And("date >= ?", page.Since)
+	}
+	if page.After != nil {
+		q.And("last_path > ?", page.After.Key)
+	}
+	posts := []Post{}
+	err = q.OrderBy("last_path", "asc").Limit(page.Limit).Select(s.Map, &posts)
+	return setDepths(posts), err
+}
+
+func (s *mysqlStore) RemovePost(id int) error {
+	return s.markPostDeleted(id, true)
+}
+
+func (s *mysqlStore) RestorePost(id int) error {
+	return s.markPostDeleted(id, false)
+}
+
+// markPostDeleted flips isDeleted and moves the thread counter only when the flag changed
+func (s *mysqlStore) markPostDeleted(id int, deleted bool) error {
+	delta := 1
+	if deleted {
+		delta = -1
+	}
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		result, err := tx.Exec("update post set isDeleted = ? where id = ? and isDeleted = ?", deleted, id, !deleted)
+		if err != nil {
+			return err
+		}
+		if changed, _ := result.RowsAffected(); changed == 0 {
+			return nil
+		}
+		_, err = tx.Exec("update thread set posts = posts + ? where id = (select thread from post where id = ?)", delta, id)
+		return err
+	})
+}
+
+// UpdatePost keeps the current message as a revision by user unless it does
+// not change and marks the post edited
+func (s *mysqlStore) UpdatePost(id int, message, user, date string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		result, err := tx.Exec("insert into post_revision (post, user, date, message) select id, ?, ?, message from post where id = ? and message <> ?",
+			user, date, id, message)
+		if err != nil {
+			return err
+		}
+		if changed, err := result.RowsAffected(); err != nil || changed == 0 {
+			return err
+		}
+		_, err = tx.Exec("update post set message = ?, isEdited = true where id = ?", message, id)
+		return err
+	})
+}
+
+func (s *mysqlStore) PostRevisions(id int) ([]Revision, error) {
+	revisions := []Revision{}
+	_, err := s.Map.Select(&revisions, "select id, post, user, date, message from post_revision where post = ? order by id", id)
+	return revisions, err
+}
+
+func (s *mysqlStore) ApprovePost(id int, approved bool) error {
+	_, err := s.Map.Exec("update post set isApproved = ? where id = ?", approved, id)
+	return err
+}
+
+func (s *mysqlStore) MarkSpam(id int, spam bool) error {
+	_, err := s.Map.Exec("update post set isSpam = ? where id = ?", spam, id)
+	return err
+}
+
+func (s *mysqlStore) HighlightPost(id int, highlighted bool) error {
+	_, err := s.Map.Exec("update post set isHighlighted = ? where id = ?", highlighted, id)
+	return err
+}
+
+func (s *mysqlStore) VotePost(id int, user string, vote int) error {
+	return s.castVote("post", id, user, vote)
+}
+
+func (s *mysqlStore) PostVotes(id int) ([]Vote, error) {
+	votes := []Vote{}
+	_, err := s.Map.Select(&votes, "select user, vote from post_vote where post = ? order by user", id)
+	return votes, err
+}
+
+// castVote replaces the vote of user on a thread or post and adjusts its
+// counters, anonymous votes with an empty user are not recorded
+func (s *mysqlStore) castVote(entity string, id int, user string, vote int) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		previous := int64(0)
+		if user != "" {
+			// locking the entity row first serializes votes on it, so concurrent
+			// votes of a user neither skew the counters nor insert twice
+			_, err := tx.SelectInt("select id from "+entity+" where id = ?"+s.ForUpdate, id)
+			if err != nil {
+				return err
+			}
+			previous, err = tx.SelectInt("select vote from "+entity+"_vote where "+entity+" = ? and user = ?"+s.ForUpdate, id, user)
+			if err != nil || int(previous) == vote {
+				return err
+			}
+			if _, err = tx.Exec("delete from "+entity+"_vote where "+entity+" = ? and user = ?", id, user); err != nil {
+				return err
+			}
+			if vote != 0 {
+				if _, err = tx.Exec("insert into "+entity+"_vote ("+entity+", user, vote) values (?, ?, ?)", id, user, vote); err != nil {
+					return err
+				}
+			}
+		}
+		likes, dislikes, points := voteDelta(int(previous), vote)
+		_, err := tx.Exec("update "+entity+" set likes = likes + ?, dislikes = dislikes + ?, points = points + ? where id = ?",
+			likes, dislikes, points, id)
+		return err
+	})
+}
+
+func (s *mysqlStore) RebuildPaths() error {
+	var threads []int
+	if _, err := s.Map.Select(&threads, "select distinct thread from post"); err != nil {
+		return err
+	}
+	for _, thread := range threads {
+		posts := []Post{}
+		if _, err := s.Map.Select(&posts, "select * from post where thread = ? order by id", thread); err != nil {
+			return err
+		}
+		treePaths(posts)
+		err := inTx(s.Map, func(tx *gorp.Transaction) error {
+			for _, post := range posts {
+				if _, err := tx.Exec("update post set first_path = ?, last_path = ?, children = ? where id = ?",
+					post.FirstPath, post.LastPath, post.Children, post.ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		log.Infof("rebuilt paths of %d posts in thread %d", len(posts), thread)
+	}
+	return nil
+}
+
+// USER
+func (s *mysqlStore) CreateUser(user *User) error {
+	result, err := s.Map.Exec("insert into user (about, name, username, isAnonymous, email) values(?, ?, ?, ?, ?)",
+		user.About, user.Name, user.Username, user.IsAnonymous, user.Email)
+	if err != nil {
+		return s.exists(err)
+	}
+	user.ID, _ = result.LastInsertId()
+	return nil
+}
+
+func (s *mysqlStore) User(email string) (User, error) {
+	user := User{}
+	err := s.Map.SelectOne(&user, "select * from user where email = ?", email)
+	return user, notFound(err)
+}
+
+func (s *mysqlStore) Users(emails []string) ([]User, error) {
+	users := []User{}
+	if len(emails) == 0 {
+		return users, nil
+	}
+	err := newQuery(s.Map.Dialect, "select * from user where email in ("+placeholders(len(emails))+")", stringArgs(emails)...).Select(s.Map, &users)
+	return users, err
+}
+
+func (s *mysqlStore) UserPosts(email string, page Page) ([]Post, error) {
+	q := newQuery(s.Map.Dialect, "select * from post where user = ?", email)
+	if page.Since != "" {
+		q.And("date >= ?", page.Since)
+	}
+	posts := []Post{}
+	err := q.Page("date", "id", page).Select(s.Map, &posts)
+	return posts, err
+}
+
+func (s *mysqlStore) UserThreads(email string, page Page) ([]Thread, error) {
+	q := newQuery(s.Map.Dialect, "select * from thread where user = ?", email)
+	if page.Since != "" {
+		q.And("date >= ?", page.Since)
+	}
+	threads := []Thread{}
+	err := q.Page("date", "id", page).Select(s.Map, &threads)
+	return threads, err
+}
+
+func (s *mysqlStore) UpdateUser(email, about, name string) error {
+	_, err := s.Map.Exec("update user set about = ?, name = ? where email = ?", about, name, email)
+	return err
+}
+
+// RemoveUser drops follows and subscriptions of a user and hands everything
+// else over to an anonymous account named alias
+func (s *mysqlStore) RemoveUser(email, alias string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		forums, err := tx.SelectInt("select count(*) from forum where user = ?", email)
+		if err != nil {
+			return err
+		}
+		if forums > 0 {
+			return ErrOwnsForum
+		}
+		if _, err := tx.Exec("delete from follow where follower = ? or following = ?", email, email); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("delete from subscription where user = ?", email); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("delete from forum_role where user = ?", email); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("delete from credential where user = ?", email); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("delete from token where user = ?", email); err != nil {
+			return err
+		}
+		statements := []string{
+			"update post set user = ? where user = ?",
+			"update thread set user = ? where user = ?",
+			"update thread_vote set user = ? where user = ?",
+			"update post_vote set user = ? where user = ?",
+			"update post_revision set user = ? where user = ?",
+			"update thread_revision set user = ? where user = ?",
+			"update user set email = ?, name = null, username = null, about = null, isAnonymous = true where email = ?",
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement, alias, email); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UserVotes returns votes of user on threads or posts as entity tells, by
+// ascending id of the thread or post and after the id of the page cursor
+func (s *mysqlStore) UserVotes(email, entity string, page Page) ([]Vote, error) {
+	q := newQuery(s.Map.Dialect, "select "+entity+", vote from "+entity+"_vote where user = ?", email)
+	if page.After != nil {
+		q.And(entity+" > ?", page.After.ID)
+	}
+	votes := []Vote{}
+	err := q.OrderBy(entity, "asc").Limit(page.Limit).Select(s.Map, &votes)
+	return votes, err
+}
+
+func (s *mysqlStore) SetPassword(email, hash string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if _, err := tx.Exec("delete from credential where user = ?", email); err != nil {
+			return err
+		}
+		_, err := tx.Exec("insert into credential (user, password) values (?, ?)", email, hash)
+		return err
+	})
+}
+
+// Password returns the password hash of a user, empty if none is set
+func (s *mysqlStore) Password(email string) (string, error) {
+	return s.Map.SelectStr("select password from credential where user = ?", email)
+}
+
+// CreateToken keeps the hash of a new token of a user until expires and drops
+// the tokens of the user expired by now
+func (s *mysqlStore) CreateToken(hash, email, now, expires string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if _, err := tx.Exec("delete from token where user = ? and expires <= ?", email, now); err != nil {
+			return err
+		}
+		_, err := tx.Exec("insert into token (token, user, expires) values (?, ?, ?)", hash, email, expires)
+		return err
+	})
+}
+
+// TokenUser returns the email of the owner of a token hash, ErrNotFound for
+// unknown tokens and those expired by now
+func (s *mysqlStore) TokenUser(hash, now string) (string, error) {
+	email, err := s.Map.SelectStr("select user from token where token = ? and expires > ?", hash, now)
+	if err == nil && email == "" {
+		err = ErrNotFound
+	}
+	return email, err
+}
+
+func (s *mysqlStore) RemoveToken(hash string) error {
+	_, err := s.Map.Exec("delete from token where token = ?", hash)
+	return err
+}
+
+// SEARCH
+// Search uses the FULLTEXT indexes of posts and threads in natural language mode
+func (s *mysqlStore) Search(query SearchQuery) ([]SearchResult, error) {
+	results := []SearchResult{}
+	if len(searchTerms(query.Text)) == 0 {
+		return results, nil
+	}
+	q := newQuery(s.Map.Dialect, "select * from (select 'post' as type, id, match(message) against (?) as score, forum, user, date, thread, '' as title, message, isDeleted, isSpam from post where match(message) against (?)",
+		query.Text, query.Text)
+	searchFilters(q, query, "user", true)
+	q.add(" union all select 'thread' as type, id, match(title, message) against (?) as score, forum, user, date, id as thread, title, message, isDeleted, false as isSpam from thread where match(title, message) against (?)",
+		query.Text, query.Text)
+	searchFilters(q, query, "user", false)
+	q.add(") as found")
+	err := q.OrderBy("score", "desc").OrderBy("id", "asc").Limit(searchLimit(query)).Offset(query.Offset).Select(s.Map, &results)
+	return results, err
+}
+
+// searchFilters adds the filters of a search query to the where clause of
+// posts or threads, threads are never spam
+func searchFilters(q *query, query SearchQuery, user string, posts bool) {
+	if query.Forum != "" {
+		q.And("forum = ?", query.Forum)
+	}
+	if query.User != "" {
+		q.And(user+" = ?", query.User)
+	}
+	if query.Since != "" {
+		q.And("date >= ?", query.Since)
+	}
+	if query.Until != "" {
+		q.And("date <= ?", query.Until)
+	}
+	if !query.Deleted {
+		q.And("isDeleted = false")
+	}
+	if posts && !query.Spam {
+		q.And("isSpam = false")
+	}
+}
+
+// searchLimit is the limit of a search query, SQL wants one for an offset
+func searchLimit(query SearchQuery) int {
+	if query.Limit == 0 && query.Offset > 0 {
+		return math.MaxInt32
+	}
+	return query.Limit
+}
+
+func (s *mysqlStore) Follow(follower, followee string) error {
+	_, err := s.Map.Exec("insert into follow (follower, following) values(?, ?)", follower, followee)
+	return s.exists(err)
+}
+
+func (s *mysqlStore) Unfollow(follower, followee string) error {
+	_, err := s.Map.Exec("delete from follow where follower = ? and following = ?", follower, followee)
+	return err
+}
+
+func (s *mysqlStore) Followers(email string, page Page) ([]string, error) {
+	q := newQuery(s.Map.Dialect, "select follower from follow join user on follower = email where following = ?", email)
+	if page.Since != "" {
+		q.And("`id` >= ?", page.Since)
+	}
+	if page.Order != "" || page.Limit > 0 || page.After != nil {
+		q.Page("follower", "", page)
+	}
+	var followers []string
+	err := q.Select(s.Map, &followers)
+	return followers, err
+}
+
+func (s *mysqlStore) Following(email string, page Page) ([]string, error) {
+	q := newQuery(s.Map.Dialect, "select following from follow join user on following = email where follower = ?", email)
+	if page.Since != "" {
+		q.And("`id` >= ?", page.Since)
+	}
+	if page.Order != "" || page.Limit > 0 || page.After != nil {
+		q.Page("following", "", page)
+	}
+	var following []string
+	err := q.Select(s.Map, &following)
+	return following, err
+}
+
+func (s *mysqlStore) Follows(emails []string) ([]Follow, error) {
+	follows := []Follow{}
+	if len(emails) == 0 {
+		return follows, nil
+	}
+	list := placeholders(len(emails))
+	args := append(stringArgs(emails), stringArgs(emails)...)
+	err := newQuery(s.Map.Dialect, "select follower, following from follow where follower in ("+list+") or following in ("+list+")", args...).Select(s.Map, &follows)
+	return follows, err
+}
@@ -0,0 +1,743 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/go-gorp/gorp"
+	"github.com/lib/pq"
+)
+
+// post paths are integer arrays, first_path and last_path are derived from them
+const pgPostColumns = `id, to_char(date, 'YYYY-MM-DD HH24:MI:SS') as date, message, parent, likes, dislikes, points,
+	isApproved, isDeleted, isEdited, isHighlighted, isSpam, forum, thread, "user",
+	path[1] as first_path, array_to_string(path[2:array_length(path, 1)], '.') as last_path`
+
+const pgThreadColumns = `id, title, to_char(date, 'YYYY-MM-DD HH24:MI:SS') as date, slug, message, likes, dislikes, points,
+	posts, isClosed, isDeleted, forum, "user"`
+
+// postgresStore keeps entities in PostgreSQL, post trees are ordered by array paths
+type postgresStore struct {
+	Map *gorp.DbMap
+}
+
+func newPostgresStore(dbmap *gorp.DbMap) *postgresStore {
+	return &postgresStore{Map: dbmap}
+}
+
+// exists maps unique key violations to ErrExists
+func exists(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrExists
+	}
+	return err
+}
+
+// COMMON
+func (s *postgresStore) Clear() error {
+	_, err := s.Map.Exec(`truncate table forum, post, "user", thread, follow, subscription, thread_vote, post_vote, post_revision, thread_revision, forum_role, credential, token restart identity`)
+	return err
+}
+
+func (s *postgresStore) Status() (map[string]int64, error) {
+	tables := []string{"forum", "post", "user", "thread"}
+	status := map[string]int64{}
+	for _, table := range tables {
+		count, err := s.Map.SelectInt(`select count(*) from "` + table + `"`)
+		if err != nil {
+			return nil, err
+		}
+		status[table] = count
+	}
+	return status, nil
+}
+
+func (s *postgresStore) Close() error {
+	return s.Map.Db.Close()
+}
+
+// FORUM
+func (s *postgresStore) CreateForum(forum *Forum) error {
+	id, err := s.Map.SelectInt(`insert into forum (name, short_name, "user", moderatorsPostClosed) values ($1, $2, $3, $4) returning id`,
+		forum.Name, forum.ShortName, forum.User, forum.ModeratorsPostClosed)
+	forum.ID = int(id)
+	return exists(err)
+}
+
+func (s *postgresStore) Forum(shortName string) (Forum, error) {
+	forum := Forum{}
+	err := s.Map.SelectOne(&forum, `select * from forum where short_name = $1`, shortName)
+	return forum, notFound(err)
+}
+
+func (s *postgresStore) ForumPosts(shortName string, page Page) ([]Post, error) {
+	q := newQuery(s.Map.Dialect, `select `+pgPostColumns+` from post where forum = ?`+hiddenPosts(page), shortName)
+	if page.Since != "" {
+		q.And(`date >= ?`, page.Since)
+	}
+	posts := []Post{}
+	err := q.Page("post.date", "post.id", page).Select(s.Map, &posts)
+	return posts, err
+}
+
+func (s *postgresStore) ModerationQueue(shortName string, page Page) ([]Post, error) {
+	q := newQuery(s.Map.Dialect, `select `+pgPostColumns+` from post where forum = ?`+queuedPosts, shortName)
+	if page.Since != "" {
+		q.And(`date >= ?`, page.Since)
+	}
+	posts := []Post{}
+	err := q.Page("post.date", "post.id", page).Select(s.Map, &posts)
+	return posts, err
+}
+
+func (s *postgresStore) ForumThreads(shortName string, page Page) ([]Thread, error) {
+	q := newQuery(s.Map.Dialect, `select `+pgThreadColumns+` from thread where forum = ?`, shortName)
+	if page.Since != "" {
+		q.And(`date >= ?`, page.Since)
+	}
+	threads := []Thread{}
+	err := q.Page("thread.date", "thread.id", page).Select(s.Map, &threads)
+	return threads, err
+}
+
+func (s *postgresStore) ForumUsers(shortName string, page Page) ([]User, error) {
+	q := newQuery(s.Map.Dialect, `select * from "user" where email in (select distinct "user" from post where forum = ?)`, shortName)
+	if page.Since != "" {
+		q.And(`id >= ?`, page.Since)
+	}
+	users := []User{}
+	err := q.Page("coalesce(name, '')", "id", page).Select(s.Map, &users)
+	return users, err
+}
+
+func (s *postgresStore) UpdateForumPolicy(shortName string, moderatorsPostClosed bool) error {
+	_, err := s.Map.Exec(`update forum set moderatorsPostClosed = $1 where short_name = $2`, moderatorsPostClosed, shortName)
+	return err
+}
+
+func (s *postgresStore) UpdateForum(shortName, name, user string) error {
+	_, err := s.Map.Exec(`update forum set name = $1, "user" = $2 where short_name = $3`, name, user, shortName)
+	return err
+}
+
+// RenameForum changes short_name of a forum and of its threads and posts
+func (s *postgresStore) RenameForum(shortName, newShortName string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if _, err := tx.Exec(`update forum set short_name = $1 where short_name = $2`, newShortName, shortName); err != nil {
+			return exists(err)
+		}
+		if _, err := tx.Exec(`update thread set forum = $1 where forum = $2`, newShortName, shortName); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`update forum_role set forum = $1 where forum = $2`, newShortName, shortName); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`update post set forum = $1 where forum = $2`, newShortName, shortName)
+		return err
+	})
+}
+
+func (s *postgresStore) RemoveForum(shortName string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if _, err := tx.Exec(`update forum set isDeleted = true where short_name = $1`, shortName); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`update thread set isDeleted = true, posts = 0 where forum = $1`, shortName); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`update post set isDeleted = true where forum = $1`, shortName)
+		return err
+	})
+}
+
+func (s *postgresStore) RestoreForum(shortName string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if _, err := tx.Exec(`update forum set isDeleted = false where short_name = $1`, shortName); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`update post set isDeleted = false where forum = $1`, shortName); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`update thread set isDeleted = false,
+			posts = (select count(id) from post where post.thread = thread.id) where forum = $1`, shortName)
+		return err
+	})
+}
+
+// ForumRole returns the stored role of user in a forum, empty if there is none
+func (s *postgresStore) ForumRole(shortName, user string) (string, error) {
+	return s.Map.SelectStr(`select role from forum_role where forum = $1 and "user" = $2`, shortName, user)
+}
+
+func (s *postgresStore) ForumRoles(shortName string) ([]Role, error) {
+	roles := []Role{}
+	_, err := s.Map.Select(&roles, `select "user", role from forum_role where forum = $1 order by "user"`, shortName)
+	return roles, err
+}
+
+func (s *postgresStore) SetForumRole(shortName, user, role string) error {
+	_, err := s.Map.Exec(`insert into forum_role (forum, "user", role) values ($1, $2, $3) on conflict (forum, "user") do update set role = excluded.role`,
+		shortName, user, role)
+	return err
+}
+
+func (s *postgresStore) RemoveForumRole(shortName, user string) error {
+	_, err := s.Map.Exec(`delete from forum_role where forum = $1 and "user" = $2`, shortName, user)
+	return err
+}
+
+// THREAD
+func (s *postgresStore) CreateThread(thread *Thread) error {
+	id, err := s.Map.SelectInt(`insert into thread (forum, "user", title, isClosed, slug, date, message, isDeleted)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`,
+		thread.Forum, thread.User, thread.Title, thread.IsClosed, thread.Slug, thread.Date, thread.Message, thread.IsDeleted)
+	thread.ID = int(id)
+	return err
+}
+
+func (s *postgresStore) Thread(id int) (Thread, error) {
+	thread := Thread{}
+	err := s.Map.SelectOne(&thread, `select `+pgThreadColumns+` from thread where id = $1`, id)
+	return thread, notFound(err)
+}
+
+func (s *postgresStore) Threads(ids []int) ([]Thread, error) {
+	threads := []Thread{}
+	if len(ids) == 0 {
+		return threads, nil
+	}
+	err := newQuery(s.Map.Dialect, `select `+pgThreadColumns+` from thread where id in (`+placeholders(len(ids))+`)`, intArgs(ids)...).Select(s.Map, &threads)
+	return threads, err
+}
+
+func (s *postgresStore) ThreadPosts(id int, sort string, page Page) ([]Post, error) {
+	filter := `thread = ?` + hiddenPosts(page)
+	args := []interface{}{id}
+	if page.Since != "" {
+		filter += ` and date >= ?`
+		args = append(args, page.Since)
+	}
+	q := newQuery(s.Map.Dialect, `select `+pgPostColumns+` from post where `+filter, args...)
+	switch sort {
+	case "tree":
+		if page.After != nil {
+			// the cursor key is last_path as listed, ids joined by dots
+			condition, cursorArgs := treeCursor(page, "path[1]", "path", `array_cat(array[?]::integer[], string_to_array(?, '.')::integer[])`,
+				page.After.Root, page.After.Key)
+			q.And(condition, cursorArgs...)
+		}
+		q.OrderBy("path[1]", page.Order).OrderBy("path", "asc").Limit(page.Limit)
+	case "parent_tree":
+		// replies are fetched for the page of root posts only
+		dir, err := direction(page.Order)
+		if err != nil {
+			return nil, err
+		}
+		roots := filter + ` and parent is null`
+		if page.After != nil {
+			roots += ` and id ` + cursorOp(dir) + ` ?`
+			args = append(args, page.After.Root)
+		}
+		roots += ` order by id ` + dir
+		if page.Limit > 0 {
+			roots += ` limit ?`
+			args = append(args, page.Limit)
+		}
+		q.And(`path[1] in (select id from post where `+roots+`)`, args...)
+		q.OrderBy("path[1]", dir).OrderBy("path", "asc")
+	default:
+		q.Page("post.date", "post.id", page)
+	}
+	posts := []Post{}
+	err := q.Select(s.Map, &posts)
+	return pgDepths(posts), err
+}
+
+// pgDepths fills the tree levels of posts from their paths, last_path holds
+// an id per level below the root
+func pgDepths(posts []Post) []Post {
+	for i := range posts {
+		if posts[i].LastPath != "" {
+			posts[i].Depth = strings.Count(posts[i].LastPath, ".") + 1
+		}
+	}
+	return posts
+}
+
+func (s *postgresStore) CloseThread(id int, closed bool) error {
+	_, err := s.Map.Exec(`update thread set isClosed = $1 where id = $2`, closed, id)
+	return err
+}
+
+func (s *postgresStore) RemoveThread(id int) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if _, err := tx.Exec(`update thread set isDeleted = true, posts = 0 where id = $1`, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`update post set isDeleted = true where thread = $1`, id)
+		return err
+	})
+}
+
+func (s *postgresStore) RestoreThread(id int) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if _, err := tx.Exec(`update thread set isDeleted = false,
+			posts = (select count(id) from post where thread = $1) where id = $1`, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`update post set isDeleted = false where thread = $1`, id)
+		return err
+	})
+}
+
+// UpdateThread keeps the current message and slug as a revision by user
+// unless nothing changes
+func (s *postgresStore) UpdateThread(id int, message, slug, user, date string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		result, err := tx.Exec(`insert into thread_revision (thread, "user", date, message, slug) select id, $1, $2, message, slug from thread where id = $3 and (message <> $4 or slug <> $5)`,
+			user, date, id, message, slug)
+		if err != nil {
+			return err
+		}
+		if changed, err := result.RowsAffected(); err != nil || changed == 0 {
+			return err
+		}
+		_, err = tx.Exec(`update thread set message = $1, slug = $2 where id = $3`, message, slug, id)
+		return err
+	})
+}
+
+func (s *postgresStore) ThreadRevisions(id int) ([]Revision, error) {
+	revisions := []Revision{}
+	_, err := s.Map.Select(&revisions, `select id, thread, "user", to_char(date, 'YYYY-MM-DD HH24:MI:SS') as date, message, slug from thread_revision where thread = $1 order by id`, id)
+	return revisions, err
+}
+
+func (s *postgresStore) VoteThread(id int, user string, vote int) error {
+	return s.castVote("thread", id, user, vote)
+}
+
+func (s *postgresStore) ThreadVotes(id int) ([]Vote, error) {
+	votes := []Vote{}
+	_, err := s.Map.Select(&votes, `select "user", vote from thread_vote where thread = $1 order by "user"`, id)
+	return votes, err
+}
+
+func (s *postgresStore) Subscribe(email string, thread int) error {
+	_, err := s.Map.Exec(`insert into subscription ("user", thread) values ($1, $2)`, email, thread)
+	return exists(err)
+}
+
+func (s *postgresStore) Unsubscribe(email string, thread int) error {
+	_, err := s.Map.Exec(`delete from subscription where "user" = $1 and thread = $2`, email, thread)
+	return err
+}
+
+func (s *postgresStore) Subscriptions(emails []string) ([]Subscription, error) {
+	subs := []Subscription{}
+	if len(emails) == 0 {
+		return subs, nil
+	}
+	err := newQuery(s.Map.Dialect, `select "user", thread from subscription where "user" in (`+placeholders(len(emails))+`) order by thread`, stringArgs(emails)...).Select(s.Map, &subs)
+	return subs, err
+}
+
+// UserSubscriptions returns threads user subscribed to by ascending id, after
+// the id of the page cursor
+func (s *postgresStore) UserSubscriptions(email string, page Page) ([]Subscription, error) {
+	q := newQuery(s.Map.Dialect, `select "user", thread from subscription where "user" = ?`, email)
+	if page.After != nil {
+		q.And(`thread > ?`, page.After.ID)
+	}
+	subs := []Subscription{}
+	err := q.OrderBy("thread", "asc").Limit(page.Limit).Select(s.Map, &subs)
+	return subs, err
+}
+
+// POST
+func (s *postgresStore) CreatePost(post *Post) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		path := "{}"
+		if post.Parent != nil {
+			parent, err := tx.SelectNullStr(`select path::text from post where id = $1`, post.Parent)
+			if err != nil {
+				return err
+			}
+			if !parent.Valid {
+				return ErrNotFound
+			}
+			path = parent.String
+		}
+		id, err := tx.SelectInt(`select nextval(pg_get_serial_sequence('post', 'id'))`)
+		if err != nil {
+			return err
+		}
+		post.ID = int(id)
+		if _, err = tx.Exec(`insert into post (id, date, forum, isApproved, isDeleted, isEdited, isHighlighted, isSpam, message, parent, thread, "user", path)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13::integer[] || $1::integer)`,
+			post.ID, post.Date, post.Forum, post.IsApproved, post.IsDeleted, post.IsEdited, post.IsHighlighted,
+			post.IsSpam, post.Message, post.Parent, post.Thread, post.User, path); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`update thread set posts = posts + 1 where id = $1`, post.Thread)
+		return err
+	})
+}
+
+func (s *postgresStore) Post(id int) (Post, error) {
+	post := Post{}
+	err := s.Map.SelectOne(&post, `select `+pgPostColumns+` from post where id = $1`, id)
+	return post, notFound(err)
+}
+
+// PostReplies finds replies by path prefix within the tree of the post, the
+// thread and root filters let the path index narrow the scan
+func (s *postgresStore) PostReplies(id, depth int, page Page) ([]Post, error) {
+	parent, err := s.Post(id)
+	if err != nil {
+		return nil, err
+	}
+	q := newQuery(s.Map.Dialect, `select `+pgPostColumns+` from post, (select path as parent_path from post where id = ?) as replied
+		where post.thread = ? and post.path[1] = ? and post.path[1:array_length(parent_path, 1)] = parent_path
+		and post.path <> parent_path`+hiddenPosts(page), id, parent.Thread, parent.FirstPath)
+	if depth > 0 {
+		q.And(`array_length(post.path, 1) <= array_length(parent_path, 1) + ?`, depth)
+	}
+	if page.Since != "" {
+		q.And(`post.date >= ?`, page.Since)
+	}
+	if page.After != nil {
+		q.And(`post.path > array_cat(array[?]::integer[], string_to_array(?, '.')::integer[])`, page.After.Root, page.After.Key)
+	}
+	posts := []Post{}
+	err = q.OrderBy("post.path", "asc").Limit(page.Limit).Select(s.Map, &posts)
+	return pgDepths(posts), err
+}
+
+func (s *postgresStore) RemovePost(id int) error {
+	return s.markPostDeleted(id, true)
+}
+
+func (s *postgresStore) RestorePost(id int) error {
+	return s.markPostDeleted(id, false)
+}
+
+// markPostDeleted flips isDeleted and moves the thread counter only when the flag changed
+func (s *postgresStore) markPostDeleted(id int, deleted bool) error {
+	delta := 1
+	if deleted {
+		delta = -1
+	}
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		result, err := tx.Exec(`update post set isDeleted = $1 where id = $2 and isDeleted = $3`, deleted, id, !deleted)
+		if err != nil {
+			return err
+		}
+		if changed, _ := result.RowsAffected(); changed == 0 {
+			return nil
+		}
+		_, err = tx.Exec(`update thread set posts = posts + $1 where id = (select thread from post where id = $2)`, delta, id)
+		return err
+	})
+}
+
+// UpdatePost keeps the current message as a revision by user unless it does
+// not change and marks the post edited
+func (s *postgresStore) UpdatePost(id int, message, user, date string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		result, err := tx.Exec(`insert into post_revision (post, "user", date, message) select id, $1, $2, message from post where id = $3 and message <> $4`,
+			user, date, id, message)
+		if err != nil {
+			return err
+		}
+		if changed, err := result.RowsAffected(); err != nil || changed == 0 {
+			return err
+		}
+		_, err = tx.Exec(`update post set message = $1, isEdited = true where id = $2`, message, id)
+		return err
+	})
+}
+
+func (s *postgresStore) PostRevisions(id int) ([]Revision, error) {
+	revisions := []Revision{}
+	_, err := s.Map.Select(&revisions, `select id, post, "user", to_char(date, 'YYYY-MM-DD HH24:MI:SS') as date, message from post_revision where post = $1 order by id`, id)
+	return revisions, err
+}
+
+func (s *postgresStore) ApprovePost(id int, approved bool) error {
+	_, err := s.Map.Exec(`update post set isApproved = $1 where id = $2`, approved, id)
+	return err
+}
+
+func (s *postgresStore) MarkSpam(id int, spam bool) error {
+	_, err := s.Map.Exec(`update post set isSpam = $1 where id = $2`, spam, id)
+	return err
+}
+
+func (s *postgresStore) HighlightPost(id int, highlighted bool) error {
+	_, err := s.Map.Exec(`update post set isHighlighted = $1 where id = $2`, highlighted, id)
+	return err
+}
+
+func (s *postgresStore) VotePost(id int, user string, vote int) error {
+	return s.castVote("post", id, user, vote)
+}
+
+func (s *postgresStore) PostVotes(id int) ([]Vote, error) {
+	votes := []Vote{}
+	_, err := s.Map.Select(&votes, `select "user", vote from post_vote where post = $1 order by "user"`, id)
+	return votes, err
+}
+
+// castVote replaces the vote of user on a thread or post and adjusts its
+// counters, anonymous votes with an empty user are not recorded
+func (s *postgresStore) castVote(entity string, id int, user string, vote int) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		previous := int64(0)
+		if user != "" {
+			// locking the entity row first serializes votes on it, so concurrent
+			// votes of a user neither skew the counters nor insert twice
+			_, err := tx.SelectInt(`select id from `+entity+` where id = $1 for update`, id)
+			if err != nil {
+				return err
+			}
+			previous, err = tx.SelectInt(`select vote from `+entity+`_vote where `+entity+` = $1 and "user" = $2 for update`, id, user)
+			if err != nil || int(previous) == vote {
+				return err
+			}
+			if _, err = tx.Exec(`delete from `+entity+`_vote where `+entity+` = $1 and "user" = $2`, id, user); err != nil {
+				return err
+			}
+			if vote != 0 {
+				if _, err = tx.Exec(`insert into `+entity+`_vote (`+entity+`, "user", vote) values ($1, $2, $3)`, id, user, vote); err != nil {
+					return err
+				}
+			}
+		}
+		likes, dislikes, points := voteDelta(int(previous), vote)
+		_, err := tx.Exec(`update `+entity+` set likes = likes + $1, dislikes = dislikes + $2, points = points + $3 where id = $4`,
+			likes, dislikes, points, id)
+		return err
+	})
+}
+
+func (s *postgresStore) RebuildPaths() error {
+	_, err := s.Map.Exec(`with recursive tree (id, path) as (
+			select id, array[id] from post where parent is null
+			union all
+			select post.id, tree.path || post.id from post join tree on post.parent = tree.id
+		)
+		update post set path = tree.path from tree where post.id = tree.id`)
+	return err
+}
+
+// USER
+func (s *postgresStore) CreateUser(user *User) error {
+	id, err := s.Map.SelectInt(`insert into "user" (about, name, username, isAnonymous, email) values ($1, $2, $3, $4, $5) returning id`,
+		user.About, user.Name, user.Username, user.IsAnonymous, user.Email)
+	if err != nil {
+		return exists(err)
+	}
+	user.ID = id
+	return nil
+}
+
+func (s *postgresStore) User(email string) (User, error) {
+	user := User{}
+	err := s.Map.SelectOne(&user, `select * from "user" where email = $1`, email)
+	return user, notFound(err)
+}
+
+func (s *postgresStore) Users(emails []string) ([]User, error) {
+	users := []User{}
+	if len(emails) == 0 {
+		return users, nil
+	}
+	err := newQuery(s.Map.Dialect, `select * from "user" where email in (`+placeholders(len(emails))+`)`, stringArgs(emails)...).Select(s.Map, &users)
+	return users, err
+}
+
+func (s *postgresStore) UserPosts(email string, page Page) ([]Post, error) {
+	q := newQuery(s.Map.Dialect, `select `+pgPostColumns+` from post where "user" = ?`, email)
+	if page.Since != "" {
+		q.And(`date >= ?`, page.Since)
+	}
+	posts := []Post{}
+	err := q.Page("post.date", "post.id", page).Select(s.Map, &posts)
+	return posts, err
+}
+
+func (s *postgresStore) UserThreads(email string, page Page) ([]Thread, error) {
+	q := newQuery(s.Map.Dialect, `select `+pgThreadColumns+` from thread where "user" = ?`, email)
+	if page.Since != "" {
+		q.And(`date >= ?`, page.Since)
+	}
+	threads := []Thread{}
+	err := q.Page("thread.date", "thread.id", page).Select(s.Map, &threads)
+	return threads, err
+}
+
+func (s *postgresStore) UpdateUser(email, about, name string) error {
+	_, err := s.Map.Exec(`update "user" set about = $1, name = $2 where email = $3`, about, name, email)
+	return err
+}
+
+// RemoveUser drops follows and subscriptions of a user and hands everything
+// else over to an anonymous account named alias
+func (s *postgresStore) RemoveUser(email, alias string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		forums, err := tx.SelectInt(`select count(*) from forum where "user" = $1`, email)
+		if err != nil {
+			return err
+		}
+		if forums > 0 {
+			return ErrOwnsForum
+		}
+		if _, err := tx.Exec(`delete from follow where follower = $1 or following = $2`, email, email); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`delete from subscription where "user" = $1`, email); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`delete from forum_role where "user" = $1`, email); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`delete from credential where "user" = $1`, email); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`delete from token where "user" = $1`, email); err != nil {
+			return err
+		}
+		statements := []string{
+			`update post set "user" = $1 where "user" = $2`,
+			`update thread set "user" = $1 where "user" = $2`,
+			`update thread_vote set "user" = $1 where "user" = $2`,
+			`update post_vote set "user" = $1 where "user" = $2`,
+			`update post_revision set "user" = $1 where "user" = $2`,
+			`update thread_revision set "user" = $1 where "user" = $2`,
+			`update "user" set email = $1, name = null, username = null, about = null, isAnonymous = true where email = $2`,
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement, alias, email); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UserVotes returns votes of user on threads or posts as entity tells, by
+// ascending id of the thread or post and after the id of the page cursor
+func (s *postgresStore) UserVotes(email, entity string, page Page) ([]Vote, error) {
+	q := newQuery(s.Map.Dialect, `select `+entity+`, vote from `+entity+`_vote where "user" = ?`, email)
+	if page.After != nil {
+		q.And(entity+` > ?`, page.After.ID)
+	}
+	votes := []Vote{}
+	err := q.OrderBy(entity, "asc").Limit(page.Limit).Select(s.Map, &votes)
+	return votes, err
+}
+
+func (s *postgresStore) SetPassword(email, hash string) error {
+	_, err := s.Map.Exec(`insert into credential ("user", password) values ($1, $2) on conflict ("user") do update set password = excluded.password`,
+		email, hash)
+	return err
+}
+
+// Password returns the password hash of a user, empty if none is set
+func (s *postgresStore) Password(email string) (string, error) {
+	return s.Map.SelectStr(`select password from credential where "user" = $1`, email)
+}
+
+// CreateToken keeps the hash of a new token of a user until expires and drops
+// the tokens of the user expired by now
+func (s *postgresStore) CreateToken(hash, email, now, expires string) error {
+	return inTx(s.Map, func(tx *gorp.Transaction) error {
+		if _, err := tx.Exec(`delete from token where "user" = $1 and expires <= $2`, email, now); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`insert into token (token, "user", expires) values ($1, $2, $3)`, hash, email, expires)
+		return err
+	})
+}
+
+// TokenUser returns the email of the owner of a token hash, ErrNotFound for
+// unknown tokens and those expired by now
+func (s *postgresStore) TokenUser(hash, now string) (string, error) {
+	email, err := s.Map.SelectStr(`select "user" from token where token = $1 and expires > $2`, hash, now)
+	if err == nil && email == "" {
+		err = ErrNotFound
+	}
+	return email, err
+}
+
+func (s *postgresStore) RemoveToken(hash string) error {
+	_, err := s.Map.Exec(`delete from token where token = $1`, hash)
+	return err
+}
+
+// SEARCH
+// Search matches any word of the query with text search vectors of posts and threads
+func (s *postgresStore) Search(query SearchQuery) ([]SearchResult, error) {
+	results := []SearchResult{}
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 {
+		return results, nil
+	}
+	text := strings.Join(terms, " | ")
+	q := newQuery(s.Map.Dialect, `select * from (select 'post' as type, id, ts_rank(to_tsvector('simple', message), to_tsquery('simple', ?)) as score, forum, "user", to_char(date, 'YYYY-MM-DD HH24:MI:SS') as date, thread, '' as title, message, isDeleted, isSpam from post where to_tsvector('simple', message) @@ to_tsquery('simple', ?)`,
+		text, text)
+	searchFilters(q, query, `"user"`, true)
+	q.add(` union all select 'thread' as type, id, ts_rank(to_tsvector('simple', title || ' ' || message), to_tsquery('simple', ?)) as score, forum, "user", to_char(date, 'YYYY-MM-DD HH24:MI:SS') as date, id as thread, title, message, isDeleted, false as isSpam from thread where to_tsvector('simple', title || ' ' || message) @@ to_tsquery('simple', ?)`,
+		text, text)
+	searchFilters(q, query, `"user"`, false)
+	q.add(`) as found`)
+	err := q.OrderBy("score", "desc").OrderBy("id", "asc").Limit(searchLimit(query)).Offset(query.Offset).Select(s.Map, &results)
+	return results, err
+}
+
+func (s *postgresStore) Follow(follower, followee string) error {
+	_, err := s.Map.Exec(`insert into follow (follower, following) values ($1, $2)`, follower, followee)
+	return exists(err)
+}
+
+func (s *postgresStore) Unfollow(follower, followee string) error {
+	_, err := s.Map.Exec(`delete from follow where follower = $1 and following = $2`, follower, followee)
+	return err
+}
+
+func (s *postgresStore) Followers(email string, page Page) ([]string, error) {
+	q := newQuery(s.Map.Dialect, `select follower from follow join "user" on follower = email where following = ?`, email)
+	if page.Since != "" {
+		q.And(`id >= ?`, page.Since)
+	}
+	if page.Order != "" || page.Limit > 0 || page.After != nil {
+		q.Page("follower", "", page)
+	}
+	var followers []string
+	err := q.Select(s.Map, &followers)
+	return followers, err
+}
+
+func (s *postgresStore) Following(email string, page Page) ([]string, error) {
+	q := newQuery(s.Map.Dialect, `select following from follow join "user" on following = email where follower = ?`, email)
+	if page.Since != "" {
+		q.And(`id >= ?`, page.Since)
+	}
+	if page.Order != "" || page.Limit > 0 || page.After != nil {
+		q.Page("following", "", page)
+	}
+	var following []string
+	err := q.Select(s.Map, &following)
+	return following, err
+}
+
+func (s *postgresStore) Follows(emails []string) ([]Follow, error) {
+	follows := []Follow{}
+	if len(emails) == 0 {
+		return follows, nil
+	}
+	list := placeholders(len(emails))
+	args := append(stringArgs(emails), stringArgs(emails)...)
+	err := newQuery(s.Map.Dialect, `select follower, following from follow where follower in (`+list+`) or following in (`+list+`)`, args...).Select(s.Map, &follows)
+	return follows, err
+}
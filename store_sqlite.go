@@ -0,0 +1,147 @@
+package main
+
+import (
+	"github.com/go-gorp/gorp"
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteStore keeps entities in a SQLite file, it reuses the MySQL queries
+// and searches with an in-process index
+type sqliteStore struct {
+	*mysqlStore
+	index *searchIndex
+}
+
+// sqliteChunk is the number of ids looked up at once, SQLite limits bind variables
+const sqliteChunk = 500
+
+func newSQLiteStore(dbmap *gorp.DbMap) *sqliteStore {
+	store := newMySQLStore(dbmap)
+	store.Duplicate = isSQLiteDuplicate
+	// SQLite has no row locks, a write transaction locks the whole database
+	store.ForUpdate = ""
+	return &sqliteStore{store, newSearchIndex()}
+}
+
+func isSQLiteDuplicate(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && sqliteErr.Code == sqlite3.ErrConstraint
+}
+
+func (s *sqliteStore) Clear() error {
+	tables := []string{"forum", "post", "user", "thread", "follow", "subscription", "thread_vote", "post_vote", "post_revision", "thread_revision", "forum_role", "credential", "token", "sqlite_sequence"}
+	for _, table := range tables {
+		if _, err := s.Map.Exec(`delete from ` + table); err != nil {
+			return err
+		}
+	}
+	s.index.Reset()
+	return nil
+}
+
+func (s *sqliteStore) CreateThread(thread *Thread) error {
+	if err := s.mysqlStore.CreateThread(thread); err != nil {
+		return err
+	}
+	s.index.Add(docKey{searchThread, thread.ID}, threadText(*thread))
+	return nil
+}
+
+func (s *sqliteStore) UpdateThread(id int, message, slug, user, date string) error {
+	if err := s.mysqlStore.UpdateThread(id, message, slug, user, date); err != nil {
+		return err
+	}
+	thread, err := s.Thread(id)
+	if err != nil {
+		return err
+	}
+	s.index.Add(docKey{searchThread, id}, threadText(thread))
+	return nil
+}
+
+func (s *sqliteStore) CreatePost(post *Post) error {
+	if err := s.mysqlStore.CreatePost(post); err != nil {
+		return err
+	}
+	s.index.Add(docKey{searchPost, post.ID}, post.Message)
+	return nil
+}
+
+func (s *sqliteStore) UpdatePost(id int, message, user, date string) error {
+	if err := s.mysqlStore.UpdatePost(id, message, user, date); err != nil {
+		return err
+	}
+	s.index.Add(docKey{searchPost, id}, message)
+	return nil
+}
+
+// Search looks words up in the index, it is filled from the database on the
+// first search and kept up to date by writes of this store
+func (s *sqliteStore) Search(query SearchQuery) ([]SearchResult, error) {
+	if err := s.index.Build(s.loadIndex); err != nil {
+		return nil, err
+	}
+	scores := s.index.Search(query.Text)
+	ids := map[string][]int{}
+	for key := range scores {
+		ids[key.Type] = append(ids[key.Type], key.ID)
+	}
+	results := []SearchResult{}
+	posts, threads := ids[searchPost], ids[searchThread]
+	for _, chunk := range chunks(posts, sqliteChunk) {
+		found := []Post{}
+		if err := newQuery(s.Map.Dialect, "select * from post where id in ("+placeholders(len(chunk))+")", intArgs(chunk)...).Select(s.Map, &found); err != nil {
+			return nil, err
+		}
+		for _, post := range found {
+			results = append(results, postResult(post, scores[docKey{searchPost, post.ID}]))
+		}
+	}
+	for _, chunk := range chunks(threads, sqliteChunk) {
+		found, err := s.Threads(chunk)
+		if err != nil {
+			return nil, err
+		}
+		for _, thread := range found {
+			results = append(results, threadResult(thread, scores[docKey{searchThread, thread.ID}]))
+		}
+	}
+	matching := results[:0]
+	for _, result := range results {
+		if query.Match(result) {
+			matching = append(matching, result)
+		}
+	}
+	return rankResults(matching, query), nil
+}
+
+// chunks splits ids into slices of at most size ids
+func chunks(ids []int, size int) [][]int {
+	result := [][]int{}
+	for len(ids) > size {
+		result = append(result, ids[:size])
+		ids = ids[size:]
+	}
+	if len(ids) > 0 {
+		result = append(result, ids)
+	}
+	return result
+}
+
+func (s *sqliteStore) loadIndex(add func(key docKey, text string)) error {
+	posts := []Post{}
+	if _, err := s.Map.Select(&posts, "select id, message from post"); err != nil {
+		return err
+	}
+	for _, post := range posts {
+		add(docKey{searchPost, post.ID}, post.Message)
+	}
+	threads := []Thread{}
+	if _, err := s.Map.Select(&threads, "select id, title, message from thread"); err != nil {
+		return err
+	}
+	for _, thread := range threads {
+		add(docKey{searchThread, thread.ID}, threadText(thread))
+	}
+	return nil
+}